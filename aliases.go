@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// StrAt joins a pack and an alias in the aliases file of a group.
+const StrAt = "@"
+
+// FollowAlias follows a chain of aliases, each naming a version or another
+// alias, to the version at its end. A name which is not an alias is its own
+// version. Return an error if the chain loops.
+func FollowAlias(aliases map[string]string, alias string) (string, error) {
+	chain := []string{alias}
+	seen := map[string]bool{alias: true}
+	for {
+		next, ok := aliases[alias]
+		if !ok {
+			return alias, nil
+		}
+		chain = append(chain, next)
+		if seen[next] {
+			return "", fmt.Errorf("Alias cycle %s", strings.Join(chain, " -> "))
+		}
+		seen[next] = true
+		alias = next
+	}
+}
+
+// Aliases merges the aliases of a pack, giving the aliases of each group
+// precedence over those of the pack, as MapGroups does for versions.
+func Aliases(pack string) map[string]string {
+	requireWarn(NeedGroups | NeedAliases)
+	return overlay(aliasesMap[pack], groupAliasesMap[pack])
+}
+
+// Copy aliases, replacing any with those of over.
+func overlay(aliases, over map[string]string) map[string]string {
+	merged := make(map[string]string, len(aliases)+len(over))
+	for alias, version := range aliases {
+		merged[alias] = version
+	}
+	for alias, version := range over {
+		merged[alias] = version
+	}
+	return merged
+}
+
+// AliasPath is the aliases file of a pack, or of a group if one is given.
+func AliasPath(group, pack string) string {
+	if group != "" {
+		return filepath.Join(group, StrAliases)
+	}
+	return PackPath(pack, StrAliases)
+}
+
+// ReadGroupAliases maps each pack to its aliases in a group. Each key of a
+// group's aliases file joins a pack and an alias, such as go@ci.
+func ReadGroupAliases(group string) (map[string]map[string]string, error) {
+	entries, err := util.ReadMap(AliasPath(group, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string]map[string]string)
+	for key, version := range entries {
+		parts := strings.SplitN(key, StrAt, 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Malformed alias %s in %s; expected <pack>%s<name>", key, AliasPath(group, ""), StrAt)
+		}
+		if aliases[parts[0]] == nil {
+			aliases[parts[0]] = make(map[string]string)
+		}
+		aliases[parts[0]][parts[1]] = version
+	}
+	return aliases, nil
+}
+
+// Read the aliases of a pack in a group, or in the pack itself if no group
+// is given. The aliases file may not exist yet.
+func readAliases(group, pack string) (map[string]string, error) {
+	if group == "" {
+		aliases, err := util.ReadMap(AliasPath(group, pack))
+		if os.IsNotExist(err) {
+			return make(map[string]string), nil
+		}
+		return aliases, err
+	}
+
+	aliases, err := ReadGroupAliases(group)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if aliases[pack] == nil {
+		return make(map[string]string), nil
+	}
+	return aliases[pack], nil
+}
+
+// Replace the aliases of a pack in a group, or in the pack itself if no
+// group is given. The aliases of other packs in a group are kept.
+func writeAliases(group, pack string, aliases map[string]string) error {
+	if group == "" {
+		return writeMap(AliasPath(group, pack), aliases)
+	}
+
+	entries, err := util.ReadMap(AliasPath(group, ""))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string]string)
+	}
+	for key := range entries {
+		if strings.HasPrefix(key, pack+StrAt) {
+			delete(entries, key)
+		}
+	}
+	for alias, version := range aliases {
+		entries[pack+StrAt+alias] = version
+	}
+	return writeMap(AliasPath(group, ""), entries)
+}
+
+// IsVersion reports whether a version of a pack is installed or available.
+func IsVersion(pack, version string) bool {
+	requireWarn(NeedInstalled | NeedAvailable)
+	return contains(installedMap[pack], version) || contains(availableMap[pack], version)
+}
+
+// AddAlias names a version of a pack in a group, or in the pack itself if
+// no group is given. The target may be a version which is installed or
+// available, or another alias which leads to one.
+func AddAlias(group, pack, target, name string) error {
+	if util.NotExist(PackPath(pack)) {
+		return fmt.Errorf("No pack %s", pack)
+	}
+	if IsVersion(pack, name) {
+		return fmt.Errorf("%s is already a version of %s", name, pack)
+	}
+
+	aliases, err := readAliases(group, pack)
+	if err != nil {
+		return err
+	}
+	aliases[name] = target
+
+	concrete, err := FollowAlias(overlay(Aliases(pack), aliases), name)
+	if err != nil {
+		return err
+	}
+	if concrete == StrLatest || concrete == StrStable {
+		concrete, _ = ComputeAlias(pack, concrete)
+	}
+	if !IsVersion(pack, concrete) {
+		return fmt.Errorf("Version %s of %s is neither installed nor available", target, pack)
+	}
+	return writeAliases(group, pack, aliases)
+}
+
+// RemoveAlias removes a name for a version of a pack from a group, or from
+// the pack itself if no group is given, unless another alias leads to it.
+func RemoveAlias(group, pack, name string) error {
+	aliases, err := readAliases(group, pack)
+	if err != nil {
+		return err
+	}
+	if _, ok := aliases[name]; !ok {
+		return fmt.Errorf("No alias %s of %s", name, pack)
+	}
+	for _, alias := range sortedKeys(aliases) {
+		if aliases[alias] == name {
+			return fmt.Errorf("Alias %s of %s points at %s; remove it first", alias, pack, name)
+		}
+	}
+
+	delete(aliases, name)
+	return writeAliases(group, pack, aliases)
+}
+
+// Find the group named by an optional trailing local argument.
+func aliasGroup(args int) string {
+	if len(os.Args) == args+1 {
+		if os.Args[args] != StrLocal {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		return LocalGroupPath
+	}
+	return ""
+}
+
+func aliasCmd() {
+	defer refreshIndex()
+	if err := AddAlias(aliasGroup(5), os.Args[2], os.Args[3], os.Args[4]); err != nil {
+		fail(err.Error())
+	}
+}
+
+func unaliasCmd() {
+	defer refreshIndex()
+	if err := RemoveAlias(aliasGroup(4), os.Args[2], os.Args[3]); err != nil {
+		fail(err.Error())
+	}
+}
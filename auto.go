@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// SettingAutoInstall is the global config setting which makes shims pull
+// missing versions. Set XVM_AUTO_INSTALL to override it.
+const SettingAutoInstall = "auto_install"
+
+// StalePullLock is how long a pull waits on another pull of the same pack
+// before assuming it was abandoned, even if the process which holds the
+// lock is still running.
+const StalePullLock = time.Hour
+
+// ConfigPath is the config file of the global group.
+func ConfigPath() string {
+	return filepath.Join(GlobalGroupPath, StrConfig)
+}
+
+// AutoInstall reports whether shims pull missing versions.
+func AutoInstall() bool {
+	value, ok := os.LookupEnv("XVM_AUTO_INSTALL")
+	if !ok {
+		config, _ := util.ReadMap(ConfigPath())
+		value = config[SettingAutoInstall]
+	}
+	on, _ := strconv.ParseBool(value)
+	return on
+}
+
+// Check if stdin is a terminal someone can answer. The null device is a
+// character device too, but nobody answers it.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// Decide whether a shim should pull its missing version: only if auto
+// install is on. Otherwise fail, explaining how to install it.
+func offerPull(missing *MissingError) bool {
+	if AutoInstall() {
+		return true
+	}
+	fail("%s; run xvm install, or set XVM_AUTO_INSTALL=1 to pull missing versions automatically", missing)
+	return false
+}
+
+// AutoPull pulls a missing version for a shim, writing progress to stderr
+// so the output of the executable is left alone. Concurrent shims wait on
+// a lock for the pack; those which waited find the version installed.
+func AutoPull(pack, version string) error {
+	unlock, err := lockPull(pack, os.Stderr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	version = ResolvePull(pack, version)
+	if !util.NotExist(PackPath(pack, StrInstalled, version)) {
+		return nil
+	}
+
+	warn("Pulling %s %s", pack, version)
+	RegisterGroups()
+	if err := pull(pack, version, os.Stderr); err != nil {
+		return err
+	}
+	refreshIndex()
+	return nil
+}
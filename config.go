@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/keyval"
+)
+
+// StrConfigs is the directory of per-version config files in a pack.
+const StrConfigs = "configs"
+
+// ConfigKeys reads the config keys a pack declares, with a description of
+// each, from the config file of its definition.
+func ConfigKeys(pack string) (map[string]string, error) {
+	keys, err := util.ReadMap(PackPath(pack, StrPack, StrConfig))
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	return keys, err
+}
+
+// PackConfigPath is the config file of a pack, or of one of its versions
+// if a version is given.
+func PackConfigPath(pack, version string) string {
+	if version != "" {
+		return PackPath(pack, StrConfigs, version)
+	}
+	return PackPath(pack, StrConfig)
+}
+
+// ReadConfig reads the config file of a pack or one of its versions, which
+// may not exist yet.
+func ReadConfig(pack, version string) (map[string]string, error) {
+	config, err := util.ReadMap(PackConfigPath(pack, version))
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	return config, err
+}
+
+// MergedConfig reads the config a version is pulled with: the config of
+// its pack, overridden by the config of the version.
+func MergedConfig(pack, version string) (map[string]string, error) {
+	config, err := ReadConfig(pack, "")
+	if err != nil {
+		return nil, err
+	}
+	versionConfig, err := ReadConfig(pack, version)
+	if err != nil {
+		return nil, err
+	}
+	return overlay(config, versionConfig), nil
+}
+
+// EncodeConfig writes a config as keyval, as pull executables read it
+// from XVM_PULL_CONFIG.
+func EncodeConfig(config map[string]string) (string, error) {
+	reader, err := keyval.NewReader(config)
+	if err != nil {
+		return "", err
+	}
+	buf, err := ioutil.ReadAll(reader)
+	return string(buf), err
+}
+
+// SetConfig sets a key declared by a pack in the config of the pack or one
+// of its versions.
+func SetConfig(pack, version, key, value string) error {
+	if util.NotExist(PackPath(pack)) {
+		return fmt.Errorf("No pack %s", pack)
+	}
+	keys, err := ConfigKeys(pack)
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[key]; !ok {
+		if len(keys) == 0 {
+			return fmt.Errorf("Pack %s declares no config", pack)
+		}
+		return fmt.Errorf("Pack %s declares no config %s; expected one of %s", pack, key, strings.Join(sortedKeys(keys), ", "))
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("Config %s must be a single line", key)
+	}
+
+	config, err := ReadConfig(pack, version)
+	if err != nil {
+		return err
+	}
+	config[key] = value
+
+	path := PackConfigPath(pack, version)
+	if err := mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return writeMap(path, config)
+}
+
+// UnsetConfig removes a key from the config of a pack or one of its
+// versions.
+func UnsetConfig(pack, version, key string) error {
+	config, err := ReadConfig(pack, version)
+	if err != nil {
+		return err
+	}
+	if _, ok := config[key]; !ok {
+		return fmt.Errorf("No config %s for %s", key, pack)
+	}
+	delete(config, key)
+	return writeMap(PackConfigPath(pack, version), config)
+}
+
+// Resolve the optional version argument of a config command, as pull would.
+func configVersion(args int) string {
+	if len(os.Args) == args+1 {
+		return ResolvePull(os.Args[3], os.Args[args])
+	}
+	return ""
+}
+
+func configCmd() {
+	switch os.Args[2] {
+	case "list":
+		argWrap(4, 5, NeedAvailable|NeedInstalled|NeedAliases, configListCmd)
+	case "get":
+		argWrap(5, 6, NeedAvailable|NeedInstalled|NeedAliases, configGetCmd)
+	case "set":
+		argWrap(6, 7, NeedAvailable|NeedInstalled|NeedAliases, journaled(configSetCmd))
+	case "unset":
+		argWrap(5, 6, NeedAvailable|NeedInstalled|NeedAliases, journaled(configUnsetCmd))
+	default:
+		fmt.Println(Usage)
+	}
+}
+
+// List the config of a pack, or the merged config of a version, followed
+// by any declared keys which are not set.
+func configListCmd() {
+	pack, version := os.Args[3], configVersion(4)
+	config, err := MergedConfig(pack, version)
+	if err != nil {
+		fail(err.Error())
+	}
+	keys, err := ConfigKeys(pack)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	for _, key := range sortedKeys(config) {
+		fmt.Printf("%s %s\n", key, config[key])
+	}
+	for _, key := range sortedKeys(keys) {
+		if _, ok := config[key]; !ok {
+			fmt.Printf("# %s %s\n", key, keys[key])
+		}
+	}
+}
+
+func configGetCmd() {
+	pack, key, version := os.Args[3], os.Args[4], configVersion(5)
+	config, err := MergedConfig(pack, version)
+	if err != nil {
+		fail(err.Error())
+	}
+	value, ok := config[key]
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println(value)
+}
+
+func configSetCmd() {
+	pack, key, value, version := os.Args[3], os.Args[4], os.Args[5], configVersion(6)
+	if err := SetConfig(pack, version, key, value); err != nil {
+		fail(err.Error())
+	}
+}
+
+func configUnsetCmd() {
+	pack, key, version := os.Args[3], os.Args[4], configVersion(5)
+	if err := UnsetConfig(pack, version, key); err != nil {
+		fail(err.Error())
+	}
+}
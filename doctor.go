@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// Severities of problems found by Diagnose.
+const (
+	SevError   = "error"
+	SevWarning = "warning"
+)
+
+// Problem is a misconfiguration found by Diagnose with a hint to fix it.
+type Problem struct {
+	Severity, Message, Hint string
+}
+
+// Diagnose reloads everything, reporting each file which fails to load,
+// and checks the versions pinned and aliases named by every group in
+// GroupPaths, the aliases and installed executables of every pack, and the
+// shim directory's place on PATH. Files which only load leniently are warned about.
+func Diagnose() (problems []Problem) {
+	report := func(severity, hint, msg string, etc ...interface{}) {
+		problems = append(problems, Problem{severity, fmt.Sprintf(msg, etc...), hint})
+	}
+
+	var errs LoadError
+	errs.add(Load(context.Background(), NeedAll))
+	for _, err := range errs {
+		report(SevError, "Fix or remove the file", "%s", err)
+	}
+
+	// Every file of a group and every aliases file should parse strictly.
+	var files []string
+	for _, group := range GroupPaths {
+		files = append(files, filepath.Join(group, StrVersions), filepath.Join(group, StrAliases))
+	}
+	for _, pack := range sortedKeys(aliasesMap) {
+		files = append(files, PackPath(pack, StrAliases))
+	}
+	for _, path := range files {
+		if err := util.CheckMap(path); err != nil && !os.IsNotExist(err) {
+			report(SevWarning, fmt.Sprintf("Edit %s", path), "%s", err)
+		}
+	}
+
+	// Every pinned version must be installed.
+	for i, group := range GroupPaths {
+		versions := groupMaps[i]
+		for _, pack := range sortedKeys(versions) {
+			version := ResolveAlias(pack, versions[pack])
+			if _, ok := ResolveInstalled(pack, version); !ok {
+				report(SevError, fmt.Sprintf("xvm pull %s %s", pack, version),
+					"%s pins %s %s, which is not installed", group, pack, version)
+			}
+		}
+	}
+
+	// Every alias must lead to a version which is installed or available.
+	checkAlias := func(aliases map[string]string, pack, alias, from, unalias, path string) {
+		version, err := FollowAlias(aliases, from)
+		if err != nil {
+			report(SevError, unalias, "%s", err)
+			return
+		}
+		if version == StrLatest || version == StrStable {
+			version, _ = ComputeAlias(pack, version)
+		}
+		if !contains(installedMap[pack], version) && !contains(availableMap[pack], version) {
+			report(SevError, fmt.Sprintf("Edit %s", path),
+				"Alias %s of %s points at %s, which is neither installed nor available", alias, pack, version)
+		}
+	}
+	for _, pack := range sortedKeys(aliasesMap) {
+		for _, alias := range sortedKeys(aliasesMap[pack]) {
+			checkAlias(aliasesMap[pack], pack, alias, alias, fmt.Sprintf("xvm unalias %s %s", pack, alias), PackPath(pack, StrAliases))
+		}
+	}
+
+	// Aliases of groups must be of packs which exist. They are followed as
+	// shims follow them, through the aliases of the nearest groups and then
+	// of the pack, starting from the version the group names.
+	for _, group := range GroupPaths {
+		aliases, err := ReadGroupAliases(group)
+		if err != nil {
+			continue
+		}
+		path := AliasPath(group, "")
+		for _, pack := range sortedKeys(aliases) {
+			if util.NotExist(PackPath(pack)) {
+				report(SevError, fmt.Sprintf("Edit %s", path), "%s has aliases of %s, which is not a pack", group, pack)
+				continue
+			}
+			merged := overlay(aliasesMap[pack], groupAliasesMap[pack])
+			for _, alias := range sortedKeys(aliases[pack]) {
+				unalias := fmt.Sprintf("Edit %s", path)
+				if group == LocalGroupPath && group != GlobalGroupPath {
+					unalias = fmt.Sprintf("xvm unalias %s %s local", pack, alias)
+				}
+				start := alias
+				if merged[alias] != aliases[pack][alias] {
+					// A nearer group overrides this alias.
+					start = aliases[pack][alias]
+				}
+				checkAlias(merged, pack, alias, start, unalias, path)
+			}
+		}
+	}
+
+	// Every installed version must provide executables.
+	for _, pack := range sortedKeys(installedMap) {
+		for _, version := range installedMap[pack] {
+			bin := PackPath(pack, StrInstalled, version, StrBin)
+			hint := fmt.Sprintf("xvm drop %s %s && xvm pull %s %s", pack, version, pack, version)
+
+			names, err := util.DirNames(bin)
+			if err != nil || len(names) == 0 {
+				report(SevError, hint, "%s %s has no executables in %s", pack, version, bin)
+				continue
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				info, err := os.Stat(filepath.Join(bin, name))
+				if err != nil || !OSExecutable(info) {
+					report(SevError, hint, "%s is not executable", filepath.Join(bin, name))
+				}
+			}
+		}
+	}
+
+	// The shim directory must come before anything it shims on PATH.
+	shims := filepath.Join(GlobalGroupPath, StrBin)
+	hint := fmt.Sprintf("Put %s first on PATH", shims)
+	path := filepath.SplitList(os.Getenv("PATH"))
+	found := false
+	for i, dir := range path {
+		if filepath.Clean(dir) == shims {
+			found = true
+			if i > 0 {
+				report(SevWarning, hint, "%s is not first on PATH", shims)
+			}
+			break
+		}
+		for _, bin := range sortedKeys(binMap) {
+			if !util.NotExist(filepath.Join(dir, bin)) {
+				report(SevError, hint, "%s shadows the shim for %s", filepath.Join(dir, bin), bin)
+			}
+		}
+	}
+	if !found {
+		report(SevError, hint, "%s is not on PATH", shims)
+	}
+
+	return problems
+}
+
+func doctorCmd() {
+	failed := false
+	for _, problem := range Diagnose() {
+		warn("%s: %s\n  hint: %s", problem.Severity, problem.Message, problem.Hint)
+		if problem.Severity == SevError {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// List the keys of a map in order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string][]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check if a list of versions contains a version.
+func contains(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// FlagDryRun may be given anywhere in the arguments of any command.
+const FlagDryRun = "--dry-run"
+
+// DryRun makes commands resolve everything as usual, but report the files
+// they would write or delete and the executables they would run instead of
+// changing anything.
+var DryRun bool
+
+// Take --dry-run out of the arguments, wherever it appears.
+func parseDryRun() {
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		if arg == FlagDryRun {
+			DryRun = true
+		} else {
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+}
+
+// Report a change a dry run skipped.
+func plan(msg string, etc ...interface{}) {
+	planTo(os.Stdout, msg, etc...)
+}
+
+// Report a change a dry run skipped to a writer.
+func planTo(w io.Writer, msg string, etc ...interface{}) {
+	fmt.Fprintf(w, "would "+msg+"\n", etc...)
+}
+
+// Write a key-val map to file and journal the keys it changed, or report
+// each pair it would hold.
+func writeMap(path string, conf map[string]string) error {
+	if !DryRun {
+		created := util.NotExist(path)
+		before, _ := util.ReadMap(path)
+		if err := util.WriteMap(path, conf); err != nil {
+			return err
+		}
+		if created {
+			record(Change{File: path, Created: true})
+		}
+		recordMap(path, before, conf)
+		return nil
+	}
+	plan("write %s", path)
+	for _, key := range sortedKeys(conf) {
+		fmt.Printf("  %s %s\n", key, conf[key])
+	}
+	return nil
+}
+
+// Delete a path and anything below it and journal it, or report it, if it
+// exists.
+func removeAll(path string) error {
+	if !DryRun {
+		if util.NotExist(path) {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		record(Change{File: path, Removed: true})
+		return nil
+	}
+	if !util.NotExist(path) {
+		plan("delete %s", path)
+	}
+	return nil
+}
+
+// Create a directory and its parents, or report it if it does not exist.
+func mkdirAll(path string) error {
+	if !DryRun {
+		return os.MkdirAll(path, util.PermPublic)
+	}
+	if util.NotExist(path) {
+		plan("create %s", path)
+	}
+	return nil
+}
+
+// Run an executable with extra environment variables and journal it, or
+// report it along with them to stdout. Values spanning lines are quoted.
+func run(stdout, stderr io.Writer, env []string, path string, arg ...string) error {
+	if !DryRun {
+		record(Change{File: path, Ran: true})
+		return util.CmdTo(stdout, stderr, env, path, arg...)
+	}
+	planTo(stdout, "run %s", strings.Join(append([]string{path}, arg...), " "))
+	for _, pair := range env {
+		if i := strings.Index(pair, "="); i >= 0 && strings.ContainsAny(pair, "\r\n") {
+			pair = pair[:i+1] + strconv.Quote(pair[i+1:])
+		}
+		fmt.Fprintf(stdout, "  %s\n", pair)
+	}
+	return nil
+}
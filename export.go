@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// Formats of the files other version managers read.
+const (
+	FormatToolVersions = "tool-versions" // asdf and mise
+	FormatNvmrc        = "nvmrc"         // nvm
+	FormatGoVersion    = "go-version"    // goenv
+	FormatJSON         = "json"
+)
+
+// Formats lists every format in the order usage shows them.
+var Formats = []string{FormatToolVersions, FormatNvmrc, FormatGoVersion, FormatJSON}
+
+// toolNames maps packs to the names asdf and mise give their plugins, where
+// they differ.
+var toolNames = map[string]string{
+	"go":   "golang",
+	"node": "nodejs",
+}
+
+// singlePacks maps formats which pin a single tool to its pack.
+var singlePacks = map[string]string{
+	FormatNvmrc:     "node",
+	FormatGoVersion: "go",
+}
+
+// DetectFormat guesses the format of a file from its name.
+func DetectFormat(path string) (string, error) {
+	switch name := filepath.Base(path); {
+	case name == ".tool-versions":
+		return FormatToolVersions, nil
+	case name == ".nvmrc" || name == ".node-version":
+		return FormatNvmrc, nil
+	case name == ".go-version":
+		return FormatGoVersion, nil
+	case filepath.Ext(name) == ".json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("Can not tell the format of %s; pass --format", path)
+}
+
+// Export writes versions in a format. Formats which pin a single tool fail
+// if its pack has no version.
+func Export(versions map[string]string, format string) ([]byte, error) {
+	if pack, ok := singlePacks[format]; ok {
+		version, ok := versions[pack]
+		if !ok {
+			return nil, fmt.Errorf("No version of %s to export as %s", pack, format)
+		}
+		return []byte(version + "\n"), nil
+	}
+
+	switch format {
+	case FormatToolVersions:
+		buf := new(bytes.Buffer)
+		for _, pack := range sortedKeys(versions) {
+			tool := pack
+			if name, ok := toolNames[pack]; ok {
+				tool = name
+			}
+			fmt.Fprintf(buf, "%s %s\n", tool, versions[pack])
+		}
+		return buf.Bytes(), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(versions, "", "  ")
+		return append(data, '\n'), err
+	}
+	return nil, fmt.Errorf("Unknown format %s; expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Import reads versions from a format. Where a .tool-versions line lists
+// several versions, the first is used, as asdf does. Versions which can not
+// be written to a versions file are rejected.
+func Import(data []byte, format string) (map[string]string, error) {
+	versions, err := importVersions(data, format)
+	if err != nil {
+		return nil, err
+	}
+	for pack, version := range versions {
+		if err := ValidPackName(pack); err != nil {
+			return nil, err
+		}
+		if version == "" || strings.IndexFunc(version, unicode.IsSpace) >= 0 {
+			return nil, fmt.Errorf("Invalid version %q of %s", version, pack)
+		}
+	}
+	return versions, nil
+}
+
+func importVersions(data []byte, format string) (map[string]string, error) {
+	versions := make(map[string]string)
+	if pack, ok := singlePacks[format]; ok {
+		version := strings.TrimSpace(string(data))
+		if version == "" || strings.ContainsAny(version, " \t\n") {
+			return nil, fmt.Errorf("Expected a single version of %s", pack)
+		}
+		versions[pack] = version
+		return versions, nil
+	}
+
+	switch format {
+	case FormatToolVersions:
+		packs := make(map[string]string)
+		for pack, tool := range toolNames {
+			packs[tool] = pack
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("No version for %s", fields[0])
+			}
+
+			pack := fields[0]
+			if name, ok := packs[pack]; ok {
+				pack = name
+			}
+			versions[pack] = fields[1]
+		}
+		return versions, scanner.Err()
+	case FormatJSON:
+		return versions, json.Unmarshal(data, &versions)
+	}
+	return nil, fmt.Errorf("Unknown format %s; expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Export the current versions, resolved as shims would run them.
+func exportCmd() {
+	if os.Args[2] != "--format" {
+		fmt.Println(Usage)
+		os.Exit(1)
+	}
+
+	versions := make(map[string]string)
+	for pack, version := range currentMap {
+		if concrete, ok := ResolveInstalled(pack, version); ok {
+			versions[pack] = concrete
+		} else {
+			versions[pack] = ResolvePull(pack, version)
+		}
+	}
+
+	data, err := Export(versions, os.Args[3])
+	if err != nil {
+		fail(err.Error())
+	}
+	os.Stdout.Write(data)
+}
+
+// Import versions into the local group, resolved as pull would, keeping any
+// it already pins.
+func importCmd() {
+	path := os.Args[2]
+
+	var format string
+	var err error
+	if len(os.Args) == 5 {
+		if os.Args[3] != "--format" {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		format = os.Args[4]
+	} else if format, err = DetectFormat(path); err != nil {
+		fail(err.Error())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail(err.Error())
+	}
+	imported, err := Import(data, format)
+	if err != nil {
+		fail("Failed to import %s: %s", path, err)
+	}
+
+	versionsPath := filepath.Join(LocalGroupPath, StrVersions)
+	versions, err := util.ReadMap(versionsPath)
+	if err != nil && !os.IsNotExist(err) {
+		fail(err.Error())
+	}
+	if versions == nil {
+		versions = make(map[string]string)
+	}
+	for pack, version := range imported {
+		versions[pack] = ResolvePull(pack, version)
+	}
+	if err := writeMap(versionsPath, versions); err != nil {
+		fail(err.Error())
+	}
+}
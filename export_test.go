@@ -0,0 +1,13 @@
+package main
+
+// Expose the loaded maps to tests in main_test.
+func InstalledMap() map[string][]string        { return installedMap }
+func AvailableMap() map[string][]string        { return availableMap }
+func PreMap() map[string]map[string]bool       { return preMap }
+func BinMap() map[string]string                { return binMap }
+func AliasesMap() map[string]map[string]string { return aliasesMap }
+func CurrentMap() map[string]string            { return currentMap }
+func SourceMap() map[string]string             { return sourceMap }
+
+// Unload forgets which maps are loaded, so Require loads them again.
+func Unload() { loaded = 0 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// External commands are executables named xvm-<command>. They are run with
+// the context xvm resolved in these environment variables.
+const (
+	ExternalPrefix = "xvm-"
+
+	EnvXVM         = "XVM"              // this executable
+	EnvGlobalGroup = "XVM_GLOBAL_GROUP" // the global group
+	EnvLocalGroup  = "XVM_LOCAL_GROUP"  // the nearest group
+	EnvGroups      = "XVM_GROUPS"       // every group which applies, nearest first
+	EnvCurrent     = "XVM_CURRENT"      // keyval of the current concrete versions
+	EnvDryRun      = "XVM_DRY_RUN"      // 1 if --dry-run was given
+)
+
+// FindExternal finds the executable of an external command. It is looked
+// for in the bin directory of the global group, then with the executables
+// of the current versions, then in the bin directories of pack definitions,
+// then on PATH. The executables of the current versions are only looked up
+// in the index, if there is one, so unknown commands load nothing.
+func FindExternal(command string) (string, error) {
+	if command == "" || strings.ContainsAny(command, `/\`) {
+		return "", fmt.Errorf("No command %s", command)
+	}
+	name := ExternalPrefix + command + OSExt
+
+	path := filepath.Join(GlobalGroupPath, StrBin, name)
+	if !util.NotExist(path) {
+		return path, nil
+	}
+	if path, err := resolveBin(name, true); err == nil {
+		return path, nil
+	}
+
+	defs, err := filepath.Glob(filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrPack, StrBin, name))
+	if err == nil && len(defs) > 0 {
+		sort.Strings(defs)
+		return defs[0], nil
+	}
+
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("No command %s", command)
+}
+
+// ExternalEnv lists the environment variables external commands are run
+// with.
+func ExternalEnv() ([]string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]string)
+	for pack, version := range currentMap {
+		if concrete, ok := ResolveInstalled(pack, version); ok {
+			current[pack] = concrete
+		} else {
+			current[pack] = ResolvePull(pack, version)
+		}
+	}
+	encoded, err := EncodeConfig(current)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := ""
+	if DryRun {
+		dryRun = "1"
+	}
+	return []string{
+		EnvXVM + "=" + self,
+		EnvGlobalGroup + "=" + GlobalGroupPath,
+		EnvLocalGroup + "=" + LocalGroupPath,
+		EnvGroups + "=" + strings.Join(GroupPaths, string(os.PathListSeparator)),
+		EnvCurrent + "=" + encoded,
+		EnvDryRun + "=" + dryRun,
+	}, nil
+}
+
+// Run an external command, passing on arguments and exiting with its
+// status. Print usage and fail if there is no such command, before loading
+// anything but the groups.
+func externalCmd() {
+	SetupGroups()
+	path, err := FindExternal(os.Args[1])
+	if err != nil {
+		fmt.Println(Usage)
+		os.Exit(1)
+	}
+	if err := Require(NeedGroups | NeedInstalled | NeedAvailable | NeedAliases); err != nil {
+		warn(err.Error())
+	}
+
+	env, err := ExternalEnv()
+	if err != nil {
+		fail(err.Error())
+	}
+	for _, pair := range env {
+		i := strings.Index(pair, "=")
+		os.Setenv(pair[:i], pair[i+1:])
+	}
+
+	code, err := util.Exec(path, os.Args[2:]...)
+	if err != nil {
+		fail(err.Error())
+	}
+	os.Exit(code)
+}
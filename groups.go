@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// RegistryPath is the file in the global group listing every known group,
+// one path per line. Paths are not stored as keyval keys because they may
+// contain spaces.
+func RegistryPath() string {
+	return filepath.Join(GlobalGroupPath, StrGroups)
+}
+
+// Read the paths in the registry, which may not exist yet.
+func readRegistry() ([]string, error) {
+	file, err := os.Open(RegistryPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var groups []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			groups = append(groups, line)
+		}
+	}
+	return groups, scanner.Err()
+}
+
+// Write the paths in the registry in order.
+func writeRegistry(groups []string) error {
+	sort.Strings(groups)
+	content := strings.Join(groups, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if DryRun {
+		plan("write %s", RegistryPath())
+		for _, group := range groups {
+			fmt.Printf("  %s\n", group)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(RegistryPath(), util.ModeClobber, util.PermPublic)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	return err
+}
+
+// RegisterGroup records a group in the registry if it is not already known.
+// The global group is always known, so it is never recorded.
+func RegisterGroup(group string) error {
+	if group == GlobalGroupPath {
+		return nil
+	}
+
+	groups, err := readRegistry()
+	if err != nil {
+		return err
+	}
+	if contains(groups, group) {
+		return nil
+	}
+	return writeRegistry(append(groups, group))
+}
+
+// RegisterGroups records every group which applies to the working
+// directory, so groups which are used but were never set from stay known.
+func RegisterGroups() {
+	for _, group := range GroupPaths {
+		if err := RegisterGroup(group); err != nil {
+			warn("Failed to register group: %s", err)
+		}
+	}
+}
+
+// UnregisterGroup removes a group from the registry.
+func UnregisterGroup(group string) error {
+	groups, err := readRegistry()
+	if err != nil {
+		return err
+	}
+
+	kept := groups[:0]
+	for _, g := range groups {
+		if g != group {
+			kept = append(kept, g)
+		}
+	}
+	return writeRegistry(kept)
+}
+
+// KnownGroups lists the global group, the groups which apply to the working
+// directory and every registered group which still exists. Groups which have
+// been deleted without xvm remove are skipped.
+func KnownGroups() ([]string, error) {
+	groups, err := readRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	known := []string{GlobalGroupPath}
+	for _, group := range append(GroupPaths, groups...) {
+		if !contains(known, group) && !util.NotExist(group) {
+			known = append(known, group)
+		}
+	}
+	return known, nil
+}
+
+// References maps each version, as "<pack> <version>", to what references
+// it: the known groups which pin or lock it and the aliases which lead to
+// it. Aliases and partial versions are resolved as set would, so each
+// reference is recorded under the version it resolves to as well as under
+// the version it names.
+func References() (map[string][]string, error) {
+	if err := Require(NeedInstalled | NeedAliases); err != nil {
+		return nil, err
+	}
+	groups, err := KnownGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	references := make(map[string][]string)
+	reference := func(pack, version, by string) {
+		versions := []string{version, ResolveAlias(pack, version)}
+		if concrete, ok := ResolveInstalled(pack, version); ok {
+			versions = append(versions, concrete)
+		}
+		for _, v := range versions {
+			if key := pack + " " + v; !contains(references[key], by) {
+				references[key] = append(references[key], by)
+			}
+		}
+	}
+	for _, group := range groups {
+		versions, err := util.ReadMap(filepath.Join(group, StrVersions))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Can not read versions of %s: %s", group, err)
+		}
+		for pack, version := range versions {
+			reference(pack, version, fmt.Sprintf("%s pins %s %s", group, pack, version))
+		}
+
+		locks, err := ReadLock(group)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Can not read lockfile of %s: %s", group, err)
+		}
+		for pack, lock := range locks {
+			reference(pack, lock.Version, fmt.Sprintf("%s locks %s %s", group, pack, lock.Version))
+		}
+
+		aliases, err := ReadGroupAliases(group)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Can not read aliases of %s: %s", group, err)
+		}
+		for pack, names := range aliases {
+			for name, version := range names {
+				reference(pack, version, fmt.Sprintf("%s aliases %s%s%s", group, pack, StrAt, name))
+			}
+		}
+	}
+	for pack, aliases := range aliasesMap {
+		for name, version := range aliases {
+			reference(pack, version, fmt.Sprintf("alias %s of %s", name, pack))
+		}
+	}
+	return references, nil
+}
+
+// Dependants lists what references a version of a pack, or any version of
+// it if no version is given, sorted.
+func Dependants(pack, version string) ([]string, error) {
+	references, err := References()
+	if err != nil {
+		return nil, err
+	}
+
+	var dependants []string
+	for key, by := range references {
+		if key != pack+" "+version && (version != "" || !strings.HasPrefix(key, pack+" ")) {
+			continue
+		}
+		for _, b := range by {
+			if !contains(dependants, b) {
+				dependants = append(dependants, b)
+			}
+		}
+	}
+	sort.Strings(dependants)
+	return dependants, nil
+}
+
+// Unreferenced maps each pack to its installed versions which no known
+// group or alias references.
+func Unreferenced() (map[string][]string, error) {
+	references, err := References()
+	if err != nil {
+		return nil, err
+	}
+
+	unreferenced := make(map[string][]string)
+	for pack, versions := range installedMap {
+		for _, version := range versions {
+			if len(references[pack+" "+version]) == 0 {
+				unreferenced[pack] = append(unreferenced[pack], version)
+			}
+		}
+	}
+	return unreferenced, nil
+}
+
+func pruneCmd() {
+	remove := len(os.Args) == 3
+	if remove && os.Args[2] != "--remove" {
+		fmt.Println(Usage)
+		os.Exit(1)
+	}
+
+	unreferenced, err := Unreferenced()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	for _, pack := range sortedKeys(unreferenced) {
+		for _, version := range unreferenced[pack] {
+			fmt.Printf("%s %s\n", pack, version)
+			if !remove {
+				continue
+			}
+			if err := removeAll(PackPath(pack, StrInstalled, version)); err != nil {
+				warn(err.Error())
+				continue
+			}
+			if err := removeAll(ReceiptPath(pack, version)); err != nil {
+				warn(err.Error())
+			}
+		}
+	}
+	if remove {
+		refreshIndex()
+	}
+}
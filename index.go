@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/keyval"
+)
+
+// Prefixes of the keys in an index file.
+const (
+	indexBin   = "bin/"
+	indexAlias = "alias/"
+	indexMtime = "mtime/"
+)
+
+// Index caches the executables and aliases of every pack in the global
+// group, so shims can resolve a binary without globbing every installation.
+// An index is only trusted while the directories it was built from keep the
+// modification times recorded in Mtimes.
+type Index struct {
+	Bins    map[string]string            // executable to pack
+	Aliases map[string]map[string]string // pack to alias to version
+	Mtimes  map[string]int64             // slash separated path below the global group to mtime
+}
+
+// IndexPath is the index file of the global group.
+func IndexPath() string {
+	return filepath.Join(GlobalGroupPath, StrIndex)
+}
+
+// Get the modification time of a path below the global group, or 0 if it
+// does not exist.
+func mtime(rel string) int64 {
+	info, err := os.Stat(filepath.Join(GlobalGroupPath, filepath.FromSlash(rel)))
+	if err != nil {
+		return 0
+	}
+	return info.ModTime().UnixNano()
+}
+
+// BuildIndex indexes the loaded executables, aliases and installations.
+// Packs without latest or stable aliases are indexed with computed ones.
+func BuildIndex() *Index {
+	index := &Index{binMap, make(map[string]map[string]string), make(map[string]int64)}
+	for pack, versions := range installedMap {
+		aliases := make(map[string]string)
+		for _, alias := range []string{StrLatest, StrStable} {
+			if concrete, ok := ComputeAlias(pack, alias); ok {
+				aliases[alias] = concrete
+			}
+		}
+		for alias, concrete := range aliasesMap[pack] {
+			aliases[alias] = concrete
+		}
+		index.Aliases[pack] = aliases
+
+		index.record(path.Join(StrPacks, pack, StrInstalled))
+		index.record(path.Join(StrPacks, pack, StrAliases))
+		index.record(path.Join(StrPacks, pack, StrAvailable))
+		index.record(path.Join(StrPacks, pack, StrRemote))
+		for _, version := range versions {
+			index.record(path.Join(StrPacks, pack, StrInstalled, version, StrBin))
+		}
+	}
+	return index
+}
+
+func (index *Index) record(rel string) {
+	index.Mtimes[rel] = mtime(rel)
+}
+
+// Fresh reports whether the index can be trusted to run an executable at
+// a version of its pack. Only the installations, aliases and available
+// versions of that pack and the executables of that version are checked.
+func (index *Index) Fresh(bin, version string) bool {
+	pack, ok := index.Bins[bin]
+	if !ok {
+		return false
+	}
+
+	rels := []string{
+		path.Join(StrPacks, pack, StrInstalled),
+		path.Join(StrPacks, pack, StrAliases),
+		path.Join(StrPacks, pack, StrAvailable),
+		path.Join(StrPacks, pack, StrRemote),
+	}
+	if version != "" {
+		rels = append(rels, path.Join(StrPacks, pack, StrInstalled, version, StrBin))
+	}
+
+	for _, rel := range rels {
+		recorded, ok := index.Mtimes[rel]
+		if !ok || recorded != mtime(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadIndex reads the index of the global group.
+func ReadIndex() (*Index, error) {
+	entries, err := util.ReadMap(IndexPath())
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{make(map[string]string), make(map[string]map[string]string), make(map[string]int64)}
+	for key, val := range entries {
+		switch {
+		case strings.HasPrefix(key, indexBin):
+			index.Bins[strings.TrimPrefix(key, indexBin)] = val
+		case strings.HasPrefix(key, indexAlias):
+			parts := strings.SplitN(strings.TrimPrefix(key, indexAlias), "/", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Malformed alias %s in %s", key, IndexPath())
+			}
+			if index.Aliases[parts[0]] == nil {
+				index.Aliases[parts[0]] = make(map[string]string)
+			}
+			index.Aliases[parts[0]][parts[1]] = val
+		case strings.HasPrefix(key, indexMtime):
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Malformed mtime %s in %s", key, IndexPath())
+			}
+			index.Mtimes[strings.TrimPrefix(key, indexMtime)] = n
+		}
+	}
+	return index, nil
+}
+
+// Write replaces the index of the global group.
+func (index *Index) Write() error {
+	entries := make(map[string]string)
+	for bin, pack := range index.Bins {
+		entries[indexBin+bin] = pack
+	}
+	for pack, aliases := range index.Aliases {
+		for alias, version := range aliases {
+			entries[indexAlias+pack+"/"+alias] = version
+		}
+	}
+	for rel, n := range index.Mtimes {
+		entries[indexMtime+rel] = strconv.FormatInt(n, 10)
+	}
+
+	// Write a temporary file and rename it over the index, so concurrent
+	// shims never read a partial index.
+	r, err := keyval.NewReader(entries)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(IndexPath()), StrIndex+".")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), IndexPath())
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// LoadIndex reloads the executables, aliases, installations and available
+// versions of every pack and indexes them without writing the index.
+func LoadIndex() (*Index, error) {
+	err := Load(context.Background(), NeedAvailable|NeedInstalled|NeedBin|NeedAliases)
+	return BuildIndex(), err
+}
+
+// UpdateIndex reloads every pack and rewrites the index from them. Only
+// commands which change installations or aliases write the index.
+func UpdateIndex() (*Index, error) {
+	var errs LoadError
+	index, err := LoadIndex()
+	errs.add(err)
+	errs.add(index.Write())
+	return index, errs.err()
+}
+
+// Rewrite the index after a command changes installations or aliases, so
+// the next shim does not have to.
+func refreshIndex() {
+	if DryRun {
+		plan("write %s", IndexPath())
+		return
+	}
+	if _, err := UpdateIndex(); err != nil {
+		warn("Failed to update index: %s", err)
+	}
+}
+
+// MissingError is returned by ResolveBin when the current version of a pack
+// is not installed.
+type MissingError struct {
+	Pack, Version string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("Version %s of %s is not installed", e.Version, e.Pack)
+}
+
+// ResolveBin finds the path of an executable for the current version of
+// its pack, which may be an alias or a partial version. Only the current
+// versions need to be loaded; executables and aliases come from the index.
+// If it is missing or stale, every pack is loaded instead, but the index is
+// left for the commands which change packs to rewrite.
+func ResolveBin(bin string) (string, error) {
+	return resolveBin(bin, false)
+}
+
+// Resolve an executable. When probing for one which may not exist, an
+// executable missing from a readable index is missing, without loading.
+func resolveBin(bin string, probe bool) (string, error) {
+	if err := Require(NeedGroups); err != nil {
+		return "", err
+	}
+	resolve := func(index *Index) (pack, version string) {
+		pack = index.Bins[bin]
+		version = currentMap[pack]
+		aliases := overlay(index.Aliases[pack], groupAliasesMap[pack])
+		if concrete, err := FollowAlias(aliases, version); err == nil {
+			version = concrete
+		}
+
+		// Match a partial version against the installations of its pack.
+		if version != "" && util.NotExist(PackPath(pack, StrInstalled, version)) {
+			installed, _ := util.DirNames(PackPath(pack, StrInstalled))
+			if concrete, ok := MatchVersion(version, installed); ok {
+				version = concrete
+			}
+		}
+		return pack, version
+	}
+
+	index, err := ReadIndex()
+	if err == nil && probe && index.Bins[bin] == "" {
+		return "", fmt.Errorf("Failed to find binary %s", bin)
+	}
+	if err == nil {
+		if _, version := resolve(index); !index.Fresh(bin, version) {
+			err = fmt.Errorf("Stale index")
+		}
+	}
+	var loadErr error
+	if err != nil {
+		index, loadErr = LoadIndex()
+	}
+
+	pack, version := resolve(index)
+	if pack == "" {
+		if loadErr != nil {
+			return "", loadErr
+		}
+		return "", fmt.Errorf("Failed to find binary %s", bin)
+	}
+	if version == "" {
+		return "", fmt.Errorf("No version set for package %s", pack)
+	}
+
+	if util.NotExist(PackPath(pack, StrInstalled, version)) {
+		return "", &MissingError{pack, version}
+	}
+	path := PackPath(pack, StrInstalled, version, StrBin, bin)
+	if util.NotExist(path) {
+		return "", fmt.Errorf("No executable %s for version %s of %s", bin, version, pack)
+	}
+	return path, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultJobs is the number of versions install pulls at once.
+const DefaultJobs = 4
+
+// Missing maps each pack whose current version is not installed to the
+// version to pull, with aliases and partial versions resolved.
+func Missing() map[string]string {
+	requireWarn(NeedGroups | NeedInstalled)
+	missing := make(map[string]string)
+	for pack, version := range currentMap {
+		if _, ok := ResolveInstalled(pack, version); !ok {
+			missing[pack] = ResolvePull(pack, version)
+		}
+	}
+	return missing
+}
+
+// PullAll pulls a version of each pack with at most jobs pulls running at
+// once. Output from each pull is written to out a line at a time, prefixed
+// with its pack and version. Map each pack which failed to its error.
+func PullAll(versions map[string]string, jobs int, out io.Writer) map[string]error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	packs := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pack := range packs {
+				w := &prefixWriter{mu: &mu, w: out, prefix: pack + " " + versions[pack] + ": "}
+				w.Write([]byte("pulling\n"))
+
+				err := Pull(pack, versions[pack], w)
+				w.Flush()
+
+				mu.Lock()
+				if err != nil {
+					failed[pack] = err
+				}
+				mu.Unlock()
+
+				if err == nil {
+					w.Write([]byte("done\n"))
+				}
+			}
+		}()
+	}
+
+	for _, pack := range sortedKeys(versions) {
+		packs <- pack
+	}
+	close(packs)
+	wg.Wait()
+
+	return failed
+}
+
+// A prefixWriter writes whole lines to a writer shared with other
+// prefixWriters, so concurrent output is not interleaved mid-line.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a final line which was not ended by a newline.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
+
+func installCmd() {
+	jobs := DefaultJobs
+	if len(os.Args) > 2 {
+		n, err := strconv.Atoi(os.Args[len(os.Args)-1])
+		if len(os.Args) != 4 || os.Args[2] != "--jobs" || err != nil || n < 1 {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		jobs = n
+	}
+
+	missing := Missing()
+	failed := PullAll(missing, jobs, os.Stdout)
+	refreshIndex()
+	if len(failed) == 0 {
+		return
+	}
+
+	packs := make([]string, 0, len(failed))
+	for pack := range failed {
+		packs = append(packs, pack)
+	}
+	sort.Strings(packs)
+
+	warn("Failed to install %d versions:", len(failed))
+	for _, pack := range packs {
+		warn("  %s %s: %s", pack, missing[pack], failed[pack])
+	}
+	exit(1)
+}
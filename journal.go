@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skotchpine/xvm/util"
+)
+
+// StrJournal is the file in the global group recording what each mutating
+// command changed, one JSON entry per line.
+const StrJournal = "journal"
+
+// Entry records a command which changed files. Undoes is the number of the
+// entry an undo reverted, counting from 1.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Command []string  `json:"command"`
+	Group   string    `json:"group"`
+	Changes []Change  `json:"changes"`
+	Undoes  int       `json:"undoes,omitempty"`
+}
+
+// Change is one key of a key-val file set from Before to After, where nil
+// means unset, a whole file or directory created or removed, or an
+// executable run, such as the pull executable push runs to publish.
+type Change struct {
+	File    string  `json:"file"`
+	Key     string  `json:"key,omitempty"`
+	Before  *string `json:"before,omitempty"`
+	After   *string `json:"after,omitempty"`
+	Created bool    `json:"created,omitempty"`
+	Removed bool    `json:"removed,omitempty"`
+	Ran     bool    `json:"ran,omitempty"`
+}
+
+// Reversible reports whether undo can revert a change: only keys of
+// versions and aliases files can be.
+func (c Change) Reversible() bool {
+	if c.Created || c.Removed || c.Ran {
+		return false
+	}
+	name := filepath.Base(c.File)
+	return name == StrVersions || name == StrAliases
+}
+
+// The entry of the running command, if it is journaled. Pulls may record
+// changes concurrently.
+var (
+	entry   *Entry
+	entryMu sync.Mutex
+)
+
+// JournalPath is the journal of the global group.
+func JournalPath() string {
+	return filepath.Join(GlobalGroupPath, StrJournal)
+}
+
+// StartEntry begins recording the changes of a command.
+func StartEntry(command []string) {
+	entryMu.Lock()
+	defer entryMu.Unlock()
+	entry = &Entry{Time: time.Now().UTC(), Command: command}
+}
+
+// FinishEntry stops recording and appends the entry to the journal if the
+// command changed anything.
+func FinishEntry() error {
+	entryMu.Lock()
+	e := entry
+	entry = nil
+	entryMu.Unlock()
+	if e == nil || len(e.Changes) == 0 {
+		return nil
+	}
+
+	e.Group = groupOf(e.Changes[0].File)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(JournalPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, util.PermPublic)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Record changes in the entry of the running command, if there is one.
+func record(changes ...Change) {
+	entryMu.Lock()
+	defer entryMu.Unlock()
+	if entry != nil {
+		entry.Changes = append(entry.Changes, changes...)
+	}
+}
+
+// Record the keys which differ between two versions of a key-val file.
+func recordMap(path string, before, after map[string]string) {
+	var changes []Change
+	for _, key := range sortedKeys(overlay(before, after)) {
+		prev, next := lookup(before, key), lookup(after, key)
+		if !equal(prev, next) {
+			changes = append(changes, Change{File: path, Key: key, Before: prev, After: next})
+		}
+	}
+	record(changes...)
+}
+
+func lookup(m map[string]string, key string) *string {
+	if val, ok := m[key]; ok {
+		return &val
+	}
+	return nil
+}
+
+// Find the group a file belongs to: the nearest directory above it which
+// is the global group or a local group.
+func groupOf(path string) string {
+	if strings.HasPrefix(path, GlobalGroupPath+string(filepath.Separator)) {
+		return GlobalGroupPath
+	}
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == OSDir {
+			return dir
+		}
+	}
+	return filepath.Dir(path)
+}
+
+// Wrap a command so its changes are journaled and the groups it is used
+// from are registered.
+func journaled(fn func()) func() {
+	return func() {
+		if !DryRun {
+			RegisterGroups()
+			StartEntry(os.Args[1:])
+		}
+		fn()
+		if err := FinishEntry(); err != nil {
+			warn("Failed to update journal: %s", err)
+		}
+	}
+}
+
+// ReadJournal reads every entry of the journal, oldest first.
+func ReadJournal() ([]Entry, error) {
+	file, err := os.Open(JournalPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	reader := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var e Entry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, fmt.Errorf("Malformed entry on line %d of %s: %s", n, JournalPath(), err)
+			}
+			entries = append(entries, e)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// Undo reverts the reversible changes of the last entry which has any and
+// has not been undone, recording the reversal in the running entry. Return
+// the number of the entry undone. Nothing is changed if a file has changed
+// since.
+func Undo() (int, error) {
+	entries, err := ReadJournal()
+	if err != nil {
+		return 0, err
+	}
+
+	undone := make(map[int]bool)
+	for _, e := range entries {
+		undone[e.Undoes] = true
+	}
+	n := len(entries)
+	for ; n > 0; n-- {
+		e := entries[n-1]
+		if e.Undoes == 0 && !undone[n] && reversible(e) {
+			break
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("Nothing to undo")
+	}
+
+	// Check every file before changing any.
+	files := make(map[string]map[string]string)
+	var order []string
+	changes := entries[n-1].Changes
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		if !c.Reversible() {
+			continue
+		}
+		if files[c.File] == nil {
+			cfg, err := util.ReadMap(c.File)
+			if os.IsNotExist(err) {
+				cfg = make(map[string]string)
+			} else if err != nil {
+				return 0, err
+			}
+			files[c.File] = cfg
+			order = append(order, c.File)
+		}
+
+		cfg := files[c.File]
+		if current := lookup(cfg, c.Key); !equal(current, c.After) {
+			return 0, fmt.Errorf("%s in %s is now %s, not %s as entry %d left it", c.Key, c.File, describe(current), describe(c.After), n)
+		}
+		if c.Before == nil {
+			delete(cfg, c.Key)
+		} else {
+			cfg[c.Key] = *c.Before
+		}
+	}
+
+	entryMu.Lock()
+	if entry != nil {
+		entry.Undoes = n
+	}
+	entryMu.Unlock()
+	for _, path := range order {
+		if err := writeMap(path, files[path]); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func reversible(e Entry) bool {
+	for _, c := range e.Changes {
+		if c.Reversible() {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Describe an optional value.
+func describe(val *string) string {
+	if val == nil {
+		return "(unset)"
+	}
+	return *val
+}
+
+// List the journal, oldest first, with the changes of each entry.
+func historyCmd() {
+	entries, err := ReadJournal()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	for i, e := range entries {
+		fmt.Printf("%d %s %s xvm %s\n", i+1, e.Time.Local().Format("2006-01-02 15:04:05"), e.Group, strings.Join(e.Command, " "))
+		if e.Undoes != 0 {
+			fmt.Printf("  undoes %d\n", e.Undoes)
+		}
+		for _, c := range e.Changes {
+			switch {
+			case c.Created:
+				fmt.Printf("  created %s\n", c.File)
+			case c.Removed:
+				fmt.Printf("  removed %s\n", c.File)
+			case c.Ran:
+				fmt.Printf("  ran %s\n", c.File)
+			default:
+				fmt.Printf("  %s %s: %s -> %s\n", c.File, c.Key, describe(c.Before), describe(c.After))
+			}
+		}
+	}
+}
+
+func undoCmd() {
+	n, err := Undo()
+	if err != nil {
+		fail(err.Error())
+	}
+	if !DryRun {
+		fmt.Printf("Undid %d\n", n)
+	}
+	refreshIndex()
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/version"
+)
+
+// LoadError aggregates an error for every file which failed to load.
+type LoadError []error
+
+func (e LoadError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Add an error to the aggregate, flattening other aggregates.
+func (e *LoadError) add(err error) {
+	if errs, ok := err.(LoadError); ok {
+		*e = append(*e, errs...)
+	} else if err != nil {
+		*e = append(*e, err)
+	}
+}
+
+// Return the aggregate, or nil if no errors were added.
+func (e LoadError) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Need is a set of data a command reads, so it loads nothing else.
+type Need uint
+
+// Data which can be needed. Every need but NeedPaths is a map with a loader.
+const (
+	NeedPaths     Need = 1 << iota // the global group and GroupPaths
+	NeedGroups                     // groupMaps, localMap, globalMap, currentMap and sourceMap
+	NeedAvailable                  // availableMap
+	NeedInstalled                  // installedMap
+	NeedBin                        // binMap
+	NeedAliases                    // aliasesMap
+
+	NeedAll = NeedPaths | NeedGroups | NeedAvailable | NeedInstalled | NeedBin | NeedAliases
+)
+
+var loaders = map[Need]func(context.Context) error{
+	NeedGroups:    MapGroups,
+	NeedAvailable: MapAvailable,
+	NeedInstalled: MapInstalled,
+	NeedBin:       MapBin,
+	NeedAliases:   MapAliases,
+}
+
+var (
+	loadMu sync.Mutex
+	loaded Need
+)
+
+// Load runs the loaders of every needed map concurrently and waits for all
+// of them, so the maps are safe to read once it returns. Maps which were
+// already loaded are reloaded. A file which fails to load is reported in
+// the returned LoadError, but does not stop other files or packs loading.
+// Loaders stop early if ctx is done.
+func Load(ctx context.Context, need Need) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return load(ctx, need)
+}
+
+// Require loads the needed maps which have not been loaded yet, so any
+// function can read a map on demand. Safe to call concurrently.
+func Require(need Need) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return load(context.Background(), need&^loaded)
+}
+
+// Require maps for a function which can not return an error, warning of any
+// which fail to load. Maps are only loaded once, so each error is warned of
+// once.
+func requireWarn(need Need) {
+	if err := Require(need); err != nil {
+		warn(err.Error())
+	}
+}
+
+func load(ctx context.Context, need Need) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs LoadError
+	)
+	for n, loader := range loaders {
+		if need&n == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(loader func(context.Context) error) {
+			defer wg.Done()
+			err := loader(ctx)
+
+			mu.Lock()
+			errs.add(err)
+			mu.Unlock()
+		}(loader)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	loaded |= need
+	return errs.err()
+}
+
+// MapGroup adds a group's versions to self and shared version maps.
+// Do not overwrite existing entries in the shared map. Self holds versions
+// as requested, but the shared map prefers versions from the group's lockfile.
+// A group without a versions file has no versions.
+func MapGroup(self, shared map[string]string, groupPath string) error {
+	versions, err := util.ReadMap(filepath.Join(groupPath, StrVersions))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	locks, err := ReadLock(groupPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Can not read lockfile of %s: %s", groupPath, err)
+	}
+
+	for pack, version := range versions {
+		// Write each version to this group's map.
+		self[pack] = version
+
+		// Write each version to the shared map only if no entry exists.
+		if _, ok := shared[pack]; !ok {
+			if lock, ok := locks[pack]; ok && lock.Requested == version {
+				version = lock.Version
+			}
+			shared[pack] = version
+		}
+	}
+	return nil
+}
+
+// MapGroupAliases maps the aliases of a group to a shared map only where
+// no nearer group has already.
+func MapGroupAliases(shared map[string]map[string]string, groupPath string) error {
+	aliases, err := ReadGroupAliases(groupPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for pack, names := range aliases {
+		if shared[pack] == nil {
+			shared[pack] = make(map[string]string)
+		}
+		for name, version := range names {
+			if _, ok := shared[pack][name]; !ok {
+				shared[pack][name] = version
+			}
+		}
+	}
+	return nil
+}
+
+// MapGroups maps the versions and aliases of every group, recording the group which
+// supplied each current version. Give each group precedence in order from
+// nearest to global.
+func MapGroups(ctx context.Context) error {
+	var errs LoadError
+	groups := make([]map[string]string, len(GroupPaths))
+	current := make(map[string]string)
+	sources := make(map[string]string)
+	aliases := make(map[string]map[string]string)
+	defer func() {
+		groupMaps, currentMap, sourceMap, groupAliasesMap = groups, current, sources, aliases
+		localMap = groups[0]
+		globalMap = groups[len(groups)-1]
+	}()
+
+	for i, group := range GroupPaths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		groups[i] = make(map[string]string)
+		errs.add(MapGroup(groups[i], current, group))
+		errs.add(MapGroupAliases(aliases, group))
+
+		for pack := range groups[i] {
+			if _, ok := sources[pack]; !ok {
+				sources[pack] = group
+			}
+		}
+	}
+	return errs.err()
+}
+
+// MapInstalled maps the installed versions of all packages.
+func MapInstalled(ctx context.Context) error {
+	installed := make(map[string][]string)
+	defer func() { installedMap = installed }()
+
+	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrInstalled, StrSplat)
+	list, err := filepath.Glob(glob)
+	if err != nil {
+		return fmt.Errorf("Can not find installed versions: %s", err)
+	}
+
+	for _, path := range list {
+		pack := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		installed[pack] = append(installed[pack], filepath.Base(path))
+	}
+	for _, versions := range installed {
+		version.Sort(versions)
+	}
+	return ctx.Err()
+}
+
+// MapBin maps the executables for installed versions of all packages.
+func MapBin(ctx context.Context) error {
+	bin := make(map[string]string)
+	defer func() { binMap = bin }()
+
+	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrInstalled, StrSplat, StrBin, StrSplat)
+	list, err := filepath.Glob(glob)
+	if err != nil {
+		return fmt.Errorf("Can not find executable versions: %s", err)
+	}
+
+	for _, path := range list {
+		dir := filepath.Dir
+		pack := filepath.Base(dir(dir(dir(dir(path)))))
+		bin[filepath.Base(path)] = pack
+	}
+	return ctx.Err()
+}
+
+// MapAvailable maps the available versions of all packages, and which of
+// them are flagged as prereleases. Versions fetched by update are merged
+// over those the definition ships.
+func MapAvailable(ctx context.Context) error {
+	available := make(map[string][]string)
+	pre := make(map[string]map[string]bool)
+	defer func() { availableMap, preMap = available, pre }()
+
+	var list []string
+	for _, name := range []string{StrAvailable, StrRemote} {
+		glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, name)
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			return fmt.Errorf("Can not find available versions: %s", err)
+		}
+		list = append(list, paths...)
+	}
+
+	var errs LoadError
+	merged := make(map[string]map[string]string)
+	for _, path := range list {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pack := filepath.Base(filepath.Dir(path))
+
+		versions, err := util.ReadMap(path)
+		if err != nil {
+			errs.add(fmt.Errorf("Can not find available versions for %s: %s", pack, err))
+			continue
+		}
+		merged[pack] = overlay(merged[pack], versions)
+	}
+
+	for pack, versions := range merged {
+		for v, info := range versions {
+			available[pack] = append(available[pack], v)
+			if contains(strings.Fields(info), StrPrerelease) {
+				if pre[pack] == nil {
+					pre[pack] = make(map[string]bool)
+				}
+				pre[pack][v] = true
+			}
+		}
+		version.Sort(available[pack])
+	}
+	return errs.err()
+}
+
+// MapAliases maps the aliases for all packages.
+func MapAliases(ctx context.Context) error {
+	aliases := make(map[string]map[string]string)
+	defer func() { aliasesMap = aliases }()
+
+	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrAliases)
+	list, err := filepath.Glob(glob)
+	if err != nil {
+		return fmt.Errorf("Can not find aliases: %s", err)
+	}
+
+	var errs LoadError
+	for _, path := range list {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pack := filepath.Base(filepath.Dir(path))
+
+		m, err := util.ReadMap(path)
+		if err != nil {
+			errs.add(fmt.Errorf("Can not find aliases for %s: %s", pack, err))
+			continue
+		}
+
+		aliases[pack] = m
+	}
+	return errs.err()
+}
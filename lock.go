@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skotchpine/xvm/util"
+	packutil "github.com/skotchpine/xvm/util/pack"
+)
+
+// Lock pins the version a group requested, which may be an alias, to the
+// concrete version it resolved to, and where and what was installed for it.
+type Lock struct {
+	Requested, Version, Checksum, Source string
+}
+
+// LockPath is the lockfile of a group.
+func LockPath(group string) string {
+	return filepath.Join(group, StrLock)
+}
+
+// ReadLock maps each pack locked by a group. Each line of a lockfile holds
+// a pack and the requested version, concrete version, checksum and source
+// of its lock. Empty fields are written as a dash.
+func ReadLock(group string) (map[string]Lock, error) {
+	entries, err := util.ReadMap(LockPath(group))
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make(map[string]Lock)
+	for pack, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("Malformed lock for %s in %s", pack, LockPath(group))
+		}
+		for i := range fields {
+			if fields[i] == "-" {
+				fields[i] = ""
+			}
+		}
+		locks[pack] = Lock{fields[0], fields[1], fields[2], fields[3]}
+	}
+	return locks, nil
+}
+
+// WriteLock replaces the lockfile of a group.
+func WriteLock(group string, locks map[string]Lock) error {
+	entries := make(map[string]string)
+	for pack, lock := range locks {
+		fields := []string{lock.Requested, lock.Version, lock.Checksum, lock.Source}
+		for i := range fields {
+			if fields[i] == "" {
+				fields[i] = "-"
+			}
+		}
+		entries[pack] = strings.Join(fields, " ")
+	}
+	return writeMap(LockPath(group), entries)
+}
+
+// ResolveLock locks every pack in a group's versions. Packs which are
+// already locked at the version the group requests keep their lock, so an
+// alias like stable does not move until the lock is removed. New locks
+// require the version to be installed.
+func ResolveLock(group string) (map[string]Lock, error) {
+	if err := Require(NeedInstalled); err != nil {
+		return nil, err
+	}
+	versions, err := util.ReadMap(filepath.Join(group, StrVersions))
+	if err != nil {
+		return nil, err
+	}
+	old, err := ReadLock(group)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	locks := make(map[string]Lock)
+	for pack, requested := range versions {
+		if lock, ok := old[pack]; ok && lock.Requested == requested {
+			locks[pack] = lock
+			continue
+		}
+
+		version, ok := ResolveInstalled(pack, requested)
+		if !ok {
+			return nil, fmt.Errorf("Version %s of %s is not installed; pull it before locking", requested, pack)
+		}
+		source, checksum, err := Receipt(pack, version)
+		if err != nil {
+			return nil, err
+		}
+		locks[pack] = Lock{requested, version, checksum, source}
+	}
+	return locks, nil
+}
+
+// StaleLock lists the differences between a group's versions and its
+// lockfile. A lockfile is stale when a pack is added, removed or requests a
+// different version than it was locked with.
+func StaleLock(group string) ([]string, error) {
+	versions, err := util.ReadMap(filepath.Join(group, StrVersions))
+	if err != nil {
+		return nil, err
+	}
+	locks, err := ReadLock(group)
+	if os.IsNotExist(err) {
+		return []string{LockPath(group) + " does not exist"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, pack := range sortedKeys(versions) {
+		lock, ok := locks[pack]
+		if !ok {
+			stale = append(stale, fmt.Sprintf("%s %s is not locked", pack, versions[pack]))
+		} else if lock.Requested != versions[pack] {
+			stale = append(stale, fmt.Sprintf("%s is locked at %s, but %s is requested", pack, lock.Requested, versions[pack]))
+		}
+	}
+	for pack := range locks {
+		if _, ok := versions[pack]; !ok {
+			stale = append(stale, fmt.Sprintf("%s is locked, but not requested", pack))
+		}
+	}
+	return stale, nil
+}
+
+// LockedChecksum finds the checksum which the lockfile of any applicable
+// group expects for a version, if any.
+func LockedChecksum(pack, version string) string {
+	for _, group := range GroupPaths {
+		if locks, err := ReadLock(group); err == nil {
+			if lock, ok := locks[pack]; ok && lock.Version == version {
+				return lock.Checksum
+			}
+		}
+	}
+	return ""
+}
+
+// ReceiptPath is the record of where an installed version came from.
+func ReceiptPath(pack, version string) string {
+	return PackPath(pack, StrReceipts, version)
+}
+
+// Receipt reads the source and checksum an installed version was pulled
+// with. Pull records a checksum of the installed tree when the pack reports
+// none, so the tree is only hashed here for versions installed without a
+// receipt. Files modified after the receipt was written are skipped, since
+// tools write caches into their own installations.
+func Receipt(pack, version string) (source, checksum string, err error) {
+	path := ReceiptPath(pack, version)
+	receipt, err := packutil.ReadReceipt(path)
+	if os.IsNotExist(err) {
+		receipt, err = new(packutil.Receipt), nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	source, checksum = receipt.Source, receipt.Checksum
+	if checksum == "" {
+		var until time.Time
+		if info, err := os.Stat(path); err == nil {
+			until = info.ModTime()
+		}
+		checksum, err = util.ChecksumUntil(PackPath(pack, StrInstalled, version), until)
+	}
+	return source, checksum, err
+}
+
+func lockCmd() {
+	if len(os.Args) == 3 {
+		if os.Args[2] != "--check" {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+
+		stale, err := StaleLock(LocalGroupPath)
+		if err != nil {
+			fail(err.Error())
+		}
+		for _, msg := range stale {
+			warn(msg)
+		}
+		if len(stale) > 0 {
+			fail("%s is stale; run xvm lock", LockPath(LocalGroupPath))
+		}
+		return
+	}
+
+	locks, err := ResolveLock(LocalGroupPath)
+	if err != nil {
+		fail(err.Error())
+	}
+	if err := WriteLock(LocalGroupPath, locks); err != nil {
+		fail(err.Error())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skotchpine/xvm/util/version"
+)
+
+// MatchVersions lists the versions which a partial version prefixes, such
+// as 1.9.2 and 1.9rc2 for 1.9, but not 1.90. If a version matches exactly,
+// it is the only match.
+func MatchVersions(partial string, versions []string) []string {
+	if contains(versions, partial) {
+		return []string{partial}
+	}
+
+	var matches []string
+	for _, v := range versions {
+		if len(v) <= len(partial) || !strings.HasPrefix(v, partial) {
+			continue
+		}
+		if next := v[len(partial)]; next < '0' || next > '9' {
+			matches = append(matches, v)
+		}
+	}
+	version.Sort(matches)
+	return matches
+}
+
+// MatchVersion picks the newest version which a partial version prefixes,
+// preferring releases to prereleases.
+func MatchVersion(partial string, versions []string) (string, bool) {
+	matches := MatchVersions(partial, versions)
+	if latest, ok := version.Latest(matches, true); ok {
+		return latest, true
+	}
+	return version.Latest(matches, false)
+}
+
+// ResolveInstalled resolves aliases and then a partial version to the
+// newest installed version of a pack it matches.
+func ResolveInstalled(pack, partial string) (string, bool) {
+	requireWarn(NeedInstalled)
+	return MatchVersion(ResolveAlias(pack, partial), installedMap[pack])
+}
+
+// ResolvePull resolves aliases and then a partial version to the newest
+// available version of a pack it matches, or the newest installed one if
+// none is available. Versions which match neither are pulled as given.
+func ResolvePull(pack, partial string) string {
+	requireWarn(NeedAvailable | NeedInstalled)
+	partial = ResolveAlias(pack, partial)
+	if concrete, ok := MatchVersion(partial, availableMap[pack]); ok {
+		return concrete
+	}
+	if concrete, ok := MatchVersion(partial, installedMap[pack]); ok {
+		return concrete
+	}
+	return partial
+}
+
+// ResolveDrop resolves aliases and then a partial version to the single
+// installed version of a pack it matches. Unlike set, drop refuses to guess
+// between several matches.
+func ResolveDrop(pack, partial string) (string, error) {
+	if err := Require(NeedInstalled); err != nil {
+		return "", err
+	}
+	partial = ResolveAlias(pack, partial)
+	matches := MatchVersions(partial, installedMap[pack])
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("Version %s of %s is not installed", partial, pack)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("Version %s of %s is ambiguous; it matches %s", partial, pack, strings.Join(matches, ", "))
+}
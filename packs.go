@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/gzip"
+	packutil "github.com/skotchpine/xvm/util/pack"
+	"github.com/skotchpine/xvm/util/tar"
+)
+
+// PackPath joins elements to the directory of a pack in the global group.
+//
+// A pack directory holds the pack's definition in pack, the record of where
+// the definition came from in source, its available versions and aliases,
+// and an installed directory with one entry per installed version.
+func PackPath(pack string, elem ...string) string {
+	return filepath.Join(append([]string{GlobalGroupPath, StrPacks, pack}, elem...)...)
+}
+
+// ValidPackName checks that a pack name is a single path element which is
+// not hidden, so the paths of a pack stay inside its own directory.
+func ValidPackName(name string) error {
+	if !validElem(name) {
+		return fmt.Errorf("Invalid pack name %q", name)
+	}
+	return nil
+}
+
+// ValidVersion checks a version name as ValidPackName checks a pack name.
+func ValidVersion(version string) error {
+	if !validElem(version) {
+		return fmt.Errorf("Invalid version %q", version)
+	}
+	return nil
+}
+
+func validElem(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`+string(filepath.Separator))
+}
+
+// AddPack installs the definition of a pack from source, replacing any
+// previous definition. The source is either a directory, a tarball (.tar,
+// .tar.gz or .tgz) or a local git repository. Git repositories are read at
+// ref, or HEAD if ref is empty.
+func AddPack(name, source, ref string) error {
+	if err := ValidPackName(name); err != nil {
+		return err
+	}
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+
+	archive, commit, err := archivePack(source, ref)
+	if err != nil {
+		return err
+	}
+
+	// Unarchive to a staging directory beside the other packs, so the
+	// definition can be validated before the old one is replaced. A dry run
+	// stages it in the temporary directory instead.
+	packs := filepath.Join(GlobalGroupPath, StrPacks)
+	if DryRun {
+		packs = ""
+	} else if err = os.MkdirAll(packs, util.PermPublic); err != nil {
+		return err
+	}
+	stage, err := ioutil.TempDir(packs, "."+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	if err = tar.Unarchive(stage, archive); err != nil {
+		return err
+	}
+	entries, err := util.DirNames(stage)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 {
+		return fmt.Errorf("Pack %s must contain exactly one root directory", source)
+	}
+	root := filepath.Join(stage, entries[0])
+	if util.NotExist(filepath.Join(root, StrBin, StrPull)) {
+		return fmt.Errorf("Pack %s has no %s executable", source, filepath.Join(StrBin, StrPull))
+	}
+
+	// Git packs are versioned by commit; others may declare a version in info.
+	version := commit
+	if version == "" {
+		if info, err := util.ReadMap(filepath.Join(root, StrInfo)); err == nil {
+			version = info[StrVersion]
+		}
+	}
+
+	if err = mkdirAll(PackPath(name)); err != nil {
+		return err
+	}
+	if err = removeAll(PackPath(name, StrPack)); err != nil {
+		return err
+	}
+	if DryRun {
+		plan("write %s from %s", PackPath(name, StrPack), source)
+	} else if err = os.Rename(root, PackPath(name, StrPack)); err != nil {
+		return err
+	} else {
+		root = PackPath(name, StrPack)
+	}
+
+	// Available versions always follow the definition, but aliases belong to
+	// the user once they exist.
+	if err = copyPackFile(root, name, StrAvailable, true); err != nil {
+		return err
+	}
+	if err = copyPackFile(root, name, StrAliases, false); err != nil {
+		return err
+	}
+
+	return writeMap(PackPath(name, StrSource), map[string]string{
+		StrSource:  source,
+		StrRef:     ref,
+		StrVersion: version,
+	})
+}
+
+// Archive a pack definition with a single root directory. Git repositories
+// are archived at ref and return the resolved commit.
+func archivePack(source, ref string) (archive io.Reader, commit string, err error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if info.IsDir() && ref == "" && util.NotExist(filepath.Join(source, ".git")) {
+		archive, err = tar.Archive(source)
+		return archive, "", err
+	}
+
+	if info.IsDir() {
+		if ref == "" {
+			ref = "HEAD"
+		}
+		out, err := util.Output("git", "-C", source, "rev-parse", "--verify", ref+"^{commit}")
+		if err != nil {
+			return nil, "", fmt.Errorf("Can not resolve %s in %s", ref, source)
+		}
+		commit = strings.TrimSpace(string(out))
+
+		out, err = util.Output("git", "-C", source, "archive", "--format=tar", "--prefix="+StrPack+"/", commit)
+		return bytes.NewReader(out), commit, err
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	switch {
+	case strings.HasSuffix(source, ".tar.gz"), strings.HasSuffix(source, ".tgz"):
+		archive, err = gzip.Decompress(file)
+	case strings.HasSuffix(source, ".tar"):
+		buf := new(bytes.Buffer)
+		_, err = io.Copy(buf, file)
+		archive = buf
+	default:
+		err = fmt.Errorf("Pack %s is not a directory, tarball or git repository", source)
+	}
+	return archive, "", err
+}
+
+// Copy a file from the definition in def into the pack directory, if it
+// exists.
+func copyPackFile(def, name, file string, clobber bool) error {
+	src := filepath.Join(def, file)
+	dst := PackPath(name, file)
+	if util.NotExist(src) || (!clobber && !util.NotExist(dst)) {
+		return nil
+	}
+	if DryRun {
+		plan("write %s", dst)
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(src)
+	if err == nil {
+		err = ioutil.WriteFile(dst, content, util.PermPublic)
+	}
+	return err
+}
+
+// UpdatePack reinstalls the definition of a pack from the source it was added from.
+func UpdatePack(name string) error {
+	if err := ValidPackName(name); err != nil {
+		return err
+	}
+	source, err := util.ReadMap(PackPath(name, StrSource))
+	if err != nil {
+		return fmt.Errorf("Pack %s was not added with xvm pack add", name)
+	}
+	return AddPack(name, source[StrSource], source[StrRef])
+}
+
+// ListPacks maps the name of each pack with a definition to its version.
+func ListPacks() (map[string]string, error) {
+	list, err := filepath.Glob(filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrPack))
+	if err != nil {
+		return nil, err
+	}
+
+	packs := make(map[string]string)
+	for _, path := range list {
+		name := filepath.Base(filepath.Dir(path))
+		source, _ := util.ReadMap(PackPath(name, StrSource))
+		packs[name] = source[StrVersion]
+	}
+	return packs, nil
+}
+
+// Pull installs a version of a pack with the pull executable of its
+// definition, writing the executable's output to out. The partial
+// installation is removed if the executable fails, or if a lockfile expects
+// a different checksum than the one received. A version which was already
+// installed is kept, along with its receipt. Pulls of the same pack wait
+// for each other, whichever xvm runs them.
+func Pull(pack, version string, out io.Writer) error {
+	if err := ValidPackName(pack); err != nil {
+		return err
+	}
+	if DryRun {
+		return pull(pack, version, out)
+	}
+	unlock, err := lockPull(pack, out)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return pull(pack, version, out)
+}
+
+// Lock a pack for pulling, telling out if another pull holds the lock.
+func lockPull(pack string, out io.Writer) (func() error, error) {
+	if util.NotExist(PackPath(pack)) {
+		return nil, fmt.Errorf("No definition for %s; add it with xvm pack add", pack)
+	}
+	return util.Lock(PackPath(pack, ".pull.lock"), StalePullLock, func() {
+		fmt.Fprintf(out, "Waiting for lock on %s; another xvm is pulling it\n", pack)
+	})
+}
+
+// Pull a version of a pack whose lock is held.
+func pull(pack, version string, out io.Writer) error {
+	if err := ValidPackName(pack); err != nil {
+		return err
+	}
+	if err := ValidVersion(version); err != nil {
+		return err
+	}
+	bin := PackPath(pack, StrPack, StrBin, StrPull)
+	if util.NotExist(bin) {
+		return fmt.Errorf("No definition for %s; add it with xvm pack add", pack)
+	}
+
+	path := PackPath(pack, StrInstalled, version)
+	receipt := ReceiptPath(pack, version)
+	existed := !util.NotExist(path)
+	oldReceipt, _ := ioutil.ReadFile(receipt)
+	for _, dir := range []string{filepath.Dir(path), filepath.Dir(receipt)} {
+		if err := mkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	if err := removeAll(receipt); err != nil {
+		return err
+	}
+
+	config, err := MergedConfig(pack, version)
+	if err != nil {
+		return err
+	}
+	encoded, err := EncodeConfig(config)
+	if err != nil {
+		return err
+	}
+
+	expected := LockedChecksum(pack, version)
+	env := []string{
+		packutil.EnvPath + "=" + path,
+		packutil.EnvVersion + "=" + version,
+		packutil.EnvReceipt + "=" + receipt,
+		packutil.EnvChecksum + "=" + expected,
+		packutil.EnvConfig + "=" + encoded,
+	}
+	err = run(out, out, env, bin)
+	if err == nil && DryRun {
+		plan("write %s", receipt)
+		return nil
+	}
+
+	// Record a checksum of the whole installation if the pack did not
+	// record one, before the tool has a chance to write caches into it.
+	var recorded *packutil.Receipt
+	var checksum string
+	if err == nil {
+		recorded, err = packutil.ReadReceipt(receipt)
+		if os.IsNotExist(err) {
+			recorded, err = new(packutil.Receipt), nil
+		}
+	}
+	if err == nil {
+		if checksum = recorded.Checksum; checksum == "" {
+			checksum, err = util.Checksum(path)
+		}
+	}
+	if err == nil {
+		err = (&packutil.Receipt{Source: recorded.Source, Checksum: checksum}).Write(receipt)
+	}
+	if err == nil && expected != "" && checksum != expected {
+		err = fmt.Errorf("Checksum %s of %s %s does not match lockfile checksum %s", checksum, pack, version, expected)
+	}
+
+	switch {
+	case err == nil:
+		if !existed {
+			record(Change{File: path, Created: true})
+		}
+	case existed && oldReceipt != nil:
+		ioutil.WriteFile(receipt, oldReceipt, util.PermPublic)
+	case existed:
+		os.RemoveAll(receipt)
+	default:
+		os.RemoveAll(path)
+		os.RemoveAll(receipt)
+	}
+	return err
+}
+
+func packCmd() {
+	switch os.Args[2] {
+	case "add":
+		argWrap(5, 6, NeedPaths, journaled(packAddCmd))
+	case "list":
+		argWrap(3, 3, NeedPaths, packListCmd)
+	case "update":
+		argWrap(3, 4, NeedPaths, journaled(packUpdateCmd))
+	case "remove":
+		argWrap(4, 5, NeedGroups|NeedInstalled|NeedAliases, journaled(packRemoveCmd))
+	default:
+		fmt.Println(Usage)
+	}
+}
+
+func packAddCmd() {
+	var ref string
+	if len(os.Args) == 6 {
+		ref = os.Args[5]
+	}
+	if err := AddPack(os.Args[3], os.Args[4], ref); err != nil {
+		fail(err.Error())
+	}
+	refreshIndex()
+}
+
+func packListCmd() {
+	packs, err := ListPacks()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if version := packs[name]; version != "" {
+			fmt.Printf("%s %s\n", name, version)
+		} else {
+			fmt.Println(name)
+		}
+	}
+}
+
+func packUpdateCmd() {
+	defer refreshIndex()
+	if len(os.Args) == 4 {
+		if err := UpdatePack(os.Args[3]); err != nil {
+			fail(err.Error())
+		}
+		return
+	}
+
+	packs, err := ListPacks()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	failed := false
+	for name := range packs {
+		if util.NotExist(PackPath(name, StrSource)) {
+			continue
+		}
+		if err := UpdatePack(name); err != nil {
+			warn("Failed to update %s: %s", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+func packRemoveCmd() {
+	force := false
+	if len(os.Args) == 5 {
+		if os.Args[4] != "--force" {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		force = true
+	}
+
+	name := os.Args[3]
+	if err := ValidPackName(name); err != nil {
+		fail(err.Error())
+	}
+	if util.NotExist(PackPath(name)) {
+		fail("Pack %s does not exist", name)
+	}
+	dropPack(name, force)
+}
+
+// Remove a pack with its definition and installed versions, as drop pack
+// and pack remove do. Refuse if anything references the pack and ask first,
+// unless forced.
+func dropPack(name string, force bool) {
+	if err := ValidPackName(name); err != nil {
+		fail(err.Error())
+	}
+
+	path := PackPath(name)
+	if !force {
+		refuseDependants("pack "+name, name, "")
+		if !util.NotExist(path) {
+			if DryRun {
+				plan("ask to drop pack %s", name)
+			} else if !interactive() {
+				fail("Can not drop pack %s without confirmation; run with --force", name)
+			} else if !confirm("Drop pack %s and its %d installed versions?", name, len(installedMap[name])) {
+				fail("Aborted")
+			}
+		}
+	}
+
+	if err := removeAll(path); err != nil {
+		fail(err.Error())
+	}
+	refreshIndex()
+}
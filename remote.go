@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/keyval"
+	"github.com/skotchpine/xvm/util/version"
+)
+
+// DefaultAvailableTTL is how long fetched versions are used before
+// available --remote fetches them again. Set XVM_AVAILABLE_TTL to override.
+const DefaultAvailableTTL = 24 * time.Hour
+
+// AvailableTTL reads the lifetime of fetched versions from the environment.
+func AvailableTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("XVM_AVAILABLE_TTL")); err == nil {
+		return ttl
+	}
+	return DefaultAvailableTTL
+}
+
+// HasRemote reports whether a pack's definition can list its versions,
+// either with a list executable or an index URL in its info.
+func HasRemote(pack string) bool {
+	if !util.NotExist(PackPath(pack, StrPack, StrBin, StrList)) {
+		return true
+	}
+	info, _ := util.ReadMap(PackPath(pack, StrPack, StrInfo))
+	return info[StrIndex] != ""
+}
+
+// Remote fetches every version of a pack from its definition. Each line of
+// the listing is a version, optionally followed by its release date and
+// the word prerelease. Listings are parsed leniently, as they may repeat
+// versions.
+func Remote(pack string) (map[string]string, error) {
+	if bin := PackPath(pack, StrPack, StrBin, StrList); !util.NotExist(bin) {
+		out, err := util.Output(bin)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list versions of %s: %s", pack, err)
+		}
+		return keyval.Parser{File: bin, Lenient: true}.Parse(bytes.NewReader(out))
+	}
+
+	info, _ := util.ReadMap(PackPath(pack, StrPack, StrInfo))
+	url := info[StrIndex]
+	if url == "" {
+		return nil, fmt.Errorf("Pack %s has no list executable or index", pack)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s: %s", url, res.Status)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, res.Body); err != nil {
+		return nil, err
+	}
+	return keyval.Parser{File: url, Lenient: true}.Parse(buf)
+}
+
+// UpdateAvailable caches the versions fetched from the definition of a pack
+// in its available.remote file, apart from the available file the
+// definition ships. The modification time of the cache records when they
+// were fetched.
+func UpdateAvailable(pack string) (map[string]string, error) {
+	if err := ValidPackName(pack); err != nil {
+		return nil, err
+	}
+	versions, err := Remote(pack)
+	if err != nil {
+		return nil, err
+	}
+	return versions, writeMap(PackPath(pack, StrRemote), versions)
+}
+
+// FreshAvailable reads the cached remote versions of a pack, fetching them
+// first if they are older than AvailableTTL.
+func FreshAvailable(pack string) (map[string]string, error) {
+	if err := ValidPackName(pack); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(PackPath(pack, StrRemote))
+	if err == nil && time.Since(info.ModTime()) < AvailableTTL() {
+		return util.ReadMap(PackPath(pack, StrRemote))
+	}
+	return UpdateAvailable(pack)
+}
+
+func updateCmd() {
+	var packs []string
+	if len(os.Args) == 3 {
+		if err := ValidPackName(os.Args[2]); err != nil {
+			fail(err.Error())
+		}
+		packs = []string{os.Args[2]}
+	} else {
+		names, err := util.DirNames(filepath.Join(GlobalGroupPath, StrPacks))
+		if err != nil {
+			fail(err.Error())
+		}
+		for _, pack := range names {
+			if HasRemote(pack) {
+				packs = append(packs, pack)
+			}
+		}
+	}
+
+	failed := false
+	for _, pack := range packs {
+		if _, err := UpdateAvailable(pack); err != nil {
+			warn("Failed to update %s: %s", pack, err)
+			failed = true
+		}
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+// Print every available version with its release date and prerelease flag.
+func availableRemoteCmd() {
+	pack := os.Args[2]
+	if err := ValidPackName(pack); err != nil {
+		fail(err.Error())
+	}
+	versions, err := FreshAvailable(pack)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	keys := sortedKeys(versions)
+	version.Sort(keys)
+	for _, v := range keys {
+		if info := versions[v]; info != "" {
+			fmt.Printf("%s %s\n", v, info)
+		} else {
+			fmt.Println(v)
+		}
+	}
+}
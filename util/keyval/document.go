@@ -0,0 +1,288 @@
+package keyval
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommentPrefix begins a comment line.
+const CommentPrefix = "#"
+
+// Section names a group of pairs which follow a header, either [Name] or
+// [Name "Sub"]. The zero Section is the top of a document, before any
+// header, and holds every pair of a document without headers.
+type Section struct {
+	Name, Sub string
+}
+
+// String formats the header of a section.
+func (s Section) String() string {
+	if s.Sub == "" {
+		return "[" + s.Name + "]"
+	}
+	return "[" + s.Name + " " + strconv.Quote(s.Sub) + "]"
+}
+
+// Line is one line of a document: a key-val pair, a section header, a
+// comment or a blank line, and the section it belongs to. Raw keeps the
+// text of lines which have not been changed.
+type Line struct {
+	Key, Val string
+	Raw      string
+	IsPair   bool
+	IsHeader bool
+	Section  Section
+}
+
+// Document is a key-val file which keeps the order of its pairs, sections,
+// comments and blank lines, so it can be modified and written back with
+// only the changed lines differing. Methods without a section act on the
+// top of the document.
+type Document struct {
+	Lines []Line
+}
+
+// ParseDocument reads a document strictly; see Parser.
+func ParseDocument(r io.Reader) (*Document, error) {
+	return Parser{}.ParseDocument(r)
+}
+
+// Find the index of the last line holding a key in a section, or -1.
+func (doc *Document) index(s Section, key string) int {
+	for i := len(doc.Lines) - 1; i >= 0; i-- {
+		line := doc.Lines[i]
+		if line.IsPair && line.Section == s && line.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Find the index of the header of a section, or -1.
+func (doc *Document) header(s Section) int {
+	for i, line := range doc.Lines {
+		if line.IsHeader && line.Section == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get the value of a key. Later lines override earlier ones, as in Parse.
+func (doc *Document) Get(key string) (string, bool) {
+	return doc.GetIn(Section{}, key)
+}
+
+// GetIn gets the value of a key in a section.
+func (doc *Document) GetIn(s Section, key string) (string, bool) {
+	if i := doc.index(s, key); i >= 0 {
+		return doc.Lines[i].Val, true
+	}
+	return "", false
+}
+
+// Keys lists the keys of a document in order.
+func (doc *Document) Keys() []string {
+	return doc.KeysIn(Section{})
+}
+
+// KeysIn lists the keys of a section in order.
+func (doc *Document) KeysIn(s Section) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, line := range doc.Lines {
+		if line.IsPair && line.Section == s && !seen[line.Key] {
+			keys = append(keys, line.Key)
+			seen[line.Key] = true
+		}
+	}
+	return keys
+}
+
+// Map copies the pairs of a document to a map.
+func (doc *Document) Map() map[string]string {
+	return doc.MapIn(Section{})
+}
+
+// MapIn copies the pairs of a section to a map.
+func (doc *Document) MapIn(s Section) map[string]string {
+	cfg := make(map[string]string)
+	for _, line := range doc.Lines {
+		if line.IsPair && line.Section == s {
+			cfg[line.Key] = line.Val
+		}
+	}
+	return cfg
+}
+
+// Sections lists the sections with headers in a document, in order.
+func (doc *Document) Sections() []Section {
+	var sections []Section
+	seen := make(map[Section]bool)
+	for _, line := range doc.Lines {
+		if line.IsHeader && !seen[line.Section] {
+			sections = append(sections, line.Section)
+			seen[line.Section] = true
+		}
+	}
+	return sections
+}
+
+// Set the value of a key in place.
+func (doc *Document) Set(key, val string) {
+	doc.SetIn(Section{}, key, val)
+}
+
+// SetIn sets the value of a key in a section in place. A new key is
+// inserted before the first key of its section which sorts after it, along
+// with any comments directly above that key, so sorted sections stay sorted.
+// A new section is appended to the document.
+func (doc *Document) SetIn(s Section, key, val string) {
+	line := Line{Key: key, Val: val, Raw: formatLine(key, val), IsPair: true, Section: s}
+	if i := doc.index(s, key); i >= 0 {
+		if doc.Lines[i].Val != val {
+			doc.Lines[i] = line
+		}
+		return
+	}
+
+	if s != (Section{}) && doc.header(s) < 0 {
+		if n := len(doc.Lines); n > 0 && strings.TrimSpace(doc.Lines[n-1].Raw) != "" {
+			doc.Lines = append(doc.Lines, Line{Section: s})
+		}
+		doc.Lines = append(doc.Lines, Line{Raw: s.String(), IsHeader: true, Section: s}, line)
+		return
+	}
+
+	at, last := -1, -1
+	for i, l := range doc.Lines {
+		if !l.IsPair || l.Section != s {
+			continue
+		}
+		if l.Key > key {
+			at = i
+			break
+		}
+		last = i
+	}
+
+	switch {
+	case at >= 0:
+		for at > 0 && isComment(doc.Lines[at-1]) && doc.Lines[at-1].Section == s {
+			at--
+		}
+	case last >= 0:
+		// Append after the last pair, leaving trailing comments last.
+		at = last + 1
+	case s == (Section{}):
+		// Insert before the first header and the blank lines above it.
+		at = len(doc.Lines)
+		for i, l := range doc.Lines {
+			if l.IsHeader {
+				at = i
+				break
+			}
+		}
+		for at > 0 && at < len(doc.Lines) && strings.TrimSpace(doc.Lines[at-1].Raw) == "" {
+			at--
+		}
+	default:
+		at = doc.header(s) + 1
+	}
+	doc.insert(at, line)
+}
+
+func (doc *Document) insert(at int, line Line) {
+	doc.Lines = append(doc.Lines, Line{})
+	copy(doc.Lines[at+1:], doc.Lines[at:])
+	doc.Lines[at] = line
+}
+
+func isComment(line Line) bool {
+	return !line.IsPair && !line.IsHeader && strings.TrimSpace(line.Raw) != ""
+}
+
+// Delete every line holding a key. Return false if there were none.
+func (doc *Document) Delete(key string) bool {
+	return doc.DeleteIn(Section{}, key)
+}
+
+// DeleteIn deletes every line holding a key in a section.
+func (doc *Document) DeleteIn(s Section, key string) bool {
+	return doc.filter(func(line Line) bool {
+		return line.IsPair && line.Section == s && line.Key == key
+	})
+}
+
+// DeleteSection deletes the header and every line of a section with a
+// header. Return false if there was no such section.
+func (doc *Document) DeleteSection(s Section) bool {
+	if s == (Section{}) {
+		return false
+	}
+	return doc.filter(func(line Line) bool { return line.Section == s })
+}
+
+// Remove lines matching a predicate. Return false if there were none.
+func (doc *Document) filter(remove func(Line) bool) bool {
+	lines := doc.Lines[:0]
+	for _, line := range doc.Lines {
+		if !remove(line) {
+			lines = append(lines, line)
+		}
+	}
+	removed := len(lines) != len(doc.Lines)
+	doc.Lines = lines
+	return removed
+}
+
+// Update makes the pairs of a document match a map, setting each of its
+// keys in sorted order and deleting any other keys.
+func (doc *Document) Update(cfg map[string]string) {
+	doc.UpdateIn(Section{}, cfg)
+}
+
+// UpdateIn makes the pairs of a section match a map.
+func (doc *Document) UpdateIn(s Section, cfg map[string]string) {
+	for _, key := range doc.KeysIn(s) {
+		if _, ok := cfg[key]; !ok {
+			doc.DeleteIn(s, key)
+		}
+	}
+	for _, key := range sortedKeys(cfg) {
+		doc.SetIn(s, key, cfg[key])
+	}
+}
+
+// WriteTo writes every line of a document, ending each with LineDelim.
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	for _, line := range doc.Lines {
+		buf.WriteString(line.Raw + LineDelim)
+	}
+	return buf.WriteTo(w)
+}
+
+// Format a key-val pair without its line delimiter, quoting values which
+// would not otherwise be read back as they are.
+func formatLine(key, val string) string {
+	if val == "" {
+		return key
+	}
+	if needsQuote(val) {
+		val = strconv.Quote(val)
+	}
+	return key + KeyValDelim + val
+}
+
+func sortedKeys(cfg map[string]string) []string {
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
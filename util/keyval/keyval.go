@@ -3,7 +3,6 @@
 package keyval
 
 import (
-	"bufio"
 	"bytes"
 	"io"
 )
@@ -15,11 +14,12 @@ const (
 	KeyValDelim = " "
 )
 
-// Implement io's Reader from a config. Forward errors from io operations.
+// Implement io's Reader from a config, with keys in sorted order. Forward
+// errors from io operations.
 func NewReader(cfg map[string]string) (io.Reader, error) {
 	buf := new(bytes.Buffer)
-	for key, val := range cfg {
-		if err := writeLine(key, val, buf); err != nil {
+	for _, key := range sortedKeys(cfg) {
+		if err := writeLine(key, cfg[key], buf); err != nil {
 			return buf, err
 		}
 	}
@@ -28,13 +28,7 @@ func NewReader(cfg map[string]string) (io.Reader, error) {
 
 // Write one key-val pair to a buffer.
 func writeLine(key, val string, buf io.Writer) (err error) {
-	var line []byte
-	if val == "" {
-		line = []byte(key + LineDelim)
-	} else {
-		line = []byte(key + KeyValDelim + val + LineDelim)
-	}
-	_, err = buf.Write(line)
+	_, err = buf.Write([]byte(formatLine(key, val) + LineDelim))
 	return
 }
 
@@ -43,38 +37,8 @@ func ParseString(s string) (cfg map[string]string, err error) {
 	return Parse(bytes.NewBufferString(s))
 }
 
-// Parse a key-value config buffer. Forward errors from os.Open if not nil.
+// Parse a key-value config buffer strictly, skipping comments and blank
+// lines; see Parser.
 func Parse(r io.Reader) (cfg map[string]string, err error) {
-	// Use bufio's Scanner and ScanLines to split the file into lines.
-	// A side-effect of this is that all \r characters will be stripped,
-	// so any \r character must be accompanied by a \n to end a line.
-	cfg = make(map[string]string)
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		key, val := parseLine(scanner.Bytes())
-		cfg[key] = val
-	}
-	return
-}
-
-func parseLine(buf []byte) (string, string) {
-	n := len(buf) - 1
-	lead := 0
-	for lead < n && buf[lead] == ' ' || buf[lead] == '\t' {
-		lead++
-	}
-	cursor := lead
-
-	for cursor < n && buf[cursor] != ' ' && buf[cursor] != '\t' {
-		cursor++
-	}
-	if cursor == n {
-		return string(buf[lead : cursor+1]), ""
-	}
-	key := string(buf[lead:cursor])
-
-	for cursor < n && buf[cursor] == ' ' || buf[cursor] == '\t' {
-		cursor++
-	}
-	return key, string(buf[cursor:])
+	return Parser{}.Parse(r)
 }
@@ -2,7 +2,11 @@ package keyval_test
 
 import (
 	"bytes"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/skotchpine/xvm/util/keyval"
 )
@@ -79,3 +83,219 @@ func TestLeadingWhitespace(t *testing.T) {
 
 	compare(t, expected, actual)
 }
+
+func TestDocumentRoundTrip(t *testing.T) {
+	text := "# pinned for the project\n\nnode 8.9.0\n\tgo\t1.9  \n# trailing\n"
+
+	doc, err := keyval.ParseDocument(bytes.NewBufferString(text))
+	notErr(t, err)
+
+	buf := new(bytes.Buffer)
+	_, err = doc.WriteTo(buf)
+	notErr(t, err)
+	if buf.String() != text {
+		t.Errorf("Expected %q to round trip, got %q", text, buf.String())
+	}
+
+	compare(t, map[string]string{"node": "8.9.0", "go": "1.9  "}, doc.Map())
+	if keys := doc.Keys(); len(keys) != 2 || keys[0] != "node" || keys[1] != "go" {
+		t.Errorf("Expected keys in document order, got %v", keys)
+	}
+}
+
+func TestDocumentUpdate(t *testing.T) {
+	text := "# header\n\n# the compiler\ngo 1.9\nnode 8.9.0\n# trailing\n"
+
+	doc, err := keyval.ParseDocument(bytes.NewBufferString(text))
+	notErr(t, err)
+	doc.Update(map[string]string{"go": "1.10", "alpha": "1", "python": "3.6", "java": "9"})
+
+	buf := new(bytes.Buffer)
+	_, err = doc.WriteTo(buf)
+	notErr(t, err)
+
+	expected := "# header\n\nalpha 1\n# the compiler\ngo 1.10\njava 9\npython 3.6\n# trailing\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	if doc.Delete("node") {
+		t.Error("Expected node to have been deleted by Update")
+	}
+	if !doc.Delete("java") {
+		t.Error("Expected to delete java")
+	}
+}
+
+func TestComments(t *testing.T) {
+	actual, err := keyval.ParseString("# comment\n\n   \nkey1 val1\n")
+	notErr(t, err)
+
+	if len(actual) != 1 {
+		t.Errorf("Expected comments and blank lines to be skipped, got %v", actual)
+	}
+	compare(t, map[string]string{"key1": "val1"}, actual)
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		text         string
+		line, column int
+	}{
+		{"key1 val1\nkey1 val2\n", 2, 1},
+		{"key1 val1\n  key2 \"val2\n", 2, 8},
+		{"key1 \"val1\" val2\n", 1, 13},
+		{"key1 \"\\q\"\n", 1, 6},
+	}
+
+	for _, test := range tests {
+		_, err := keyval.Parser{File: "versions"}.Parse(bytes.NewBufferString(test.text))
+		syntax, ok := err.(*keyval.SyntaxError)
+		if !ok {
+			t.Errorf("Expected a syntax error parsing %q, got %v", test.text, err)
+			continue
+		}
+		if syntax.File != "versions" || syntax.Line != test.line || syntax.Column != test.column {
+			t.Errorf("Expected an error at versions:%d:%d parsing %q, got %s", test.line, test.column, test.text, syntax)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	actual, err := keyval.Parser{Lenient: true}.Parse(bytes.NewBufferString("key1 val1\nkey1 val2\nkey2 \"val2\n"))
+	notErr(t, err)
+
+	compare(t, map[string]string{"key1": "val2", "key2": "\"val2"}, actual)
+}
+
+func TestQuoted(t *testing.T) {
+	expected := map[string]string{"key1": " padded\tvalue ", "key2": "say \"hi\"", "key3": "\"quoted\""}
+
+	reader, err := keyval.NewReader(expected)
+	notErr(t, err)
+
+	actual, err := keyval.Parse(reader)
+	notErr(t, err)
+
+	compare(t, expected, actual)
+}
+
+func TestLongLine(t *testing.T) {
+	long := strings.Repeat("v", 100000)
+
+	actual, err := keyval.ParseString("key1\n\nkey2 " + long + "\r\nkey3 val3")
+	notErr(t, err)
+
+	compare(t, map[string]string{"key1": "", "key2": long, "key3": "val3"}, actual)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("broken")
+}
+
+func TestReadError(t *testing.T) {
+	if _, err := keyval.Parse(errReader{}); err == nil {
+		t.Error("Expected a read error to be returned")
+	}
+}
+
+type manifest struct {
+	Name     string        `keyval:"name"`
+	Jobs     int           `keyval:"jobs"`
+	Verbose  bool          `keyval:"verbose,omitempty"`
+	TTL      time.Duration `keyval:"ttl"`
+	Bins     []string      `keyval:"bins,omitempty"`
+	Comment  string        `keyval:"comment,omitempty"`
+	Internal string        `keyval:"-"`
+	Untagged string
+}
+
+func TestMarshal(t *testing.T) {
+	expected := manifest{
+		Name:     "go",
+		Jobs:     4,
+		TTL:      90 * time.Minute,
+		Bins:     []string{"go", "gofmt"},
+		Comment:  " padded ",
+		Internal: "skipped",
+		Untagged: "kept",
+	}
+
+	data, err := keyval.Marshal(&expected)
+	notErr(t, err)
+	text := "name go\njobs 4\nttl 1h30m0s\nbins go gofmt\ncomment \" padded \"\nUntagged kept\n"
+	if string(data) != text {
+		t.Errorf("Expected %q, got %q", text, data)
+	}
+
+	var actual manifest
+	notErr(t, keyval.Unmarshal(data, &actual))
+	expected.Internal = ""
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var m manifest
+	if err := keyval.Unmarshal([]byte("jobs many\n"), &m); err == nil {
+		t.Error("Expected an error for a malformed int")
+	}
+	if err := keyval.Unmarshal([]byte("ttl 3 days\n"), &m); err == nil {
+		t.Error("Expected an error for a malformed duration")
+	}
+	if err := keyval.Unmarshal([]byte("name go\n"), m); err == nil {
+		t.Error("Expected an error unmarshalling into a value")
+	}
+	if _, err := keyval.Marshal(manifest{Bins: []string{"two words"}}); err == nil {
+		t.Error("Expected an error marshalling an element with whitespace")
+	}
+}
+
+func TestSections(t *testing.T) {
+	text := "name go\n\n# download urls\n[url \"linux\"]\namd64 https://localhost/linux-amd64.tgz\n\n[url \"darwin\"]\namd64 https://localhost/darwin-amd64.tgz\n[auth]\nname go\n"
+
+	doc, err := keyval.ParseDocument(bytes.NewBufferString(text))
+	notErr(t, err)
+
+	compare(t, map[string]string{"name": "go"}, doc.Map())
+	if len(doc.Map()) != 1 {
+		t.Errorf("Expected only name at the top, got %v", doc.Map())
+	}
+	linux := keyval.Section{Name: "url", Sub: "linux"}
+	compare(t, map[string]string{"amd64": "https://localhost/linux-amd64.tgz"}, doc.MapIn(linux))
+
+	sections := doc.Sections()
+	if len(sections) != 3 || sections[0] != linux || sections[2] != (keyval.Section{Name: "auth"}) {
+		t.Errorf("Expected sections in order, got %v", sections)
+	}
+
+	doc.SetIn(linux, "arm", "https://localhost/linux-arm.tgz")
+	doc.SetIn(keyval.Section{Name: "url", Sub: "windows"}, "amd64", "https://localhost/windows-amd64.zip")
+	doc.Set("checksum", "sha256:1234")
+	doc.DeleteSection(keyval.Section{Name: "auth"})
+
+	buf := new(bytes.Buffer)
+	_, err = doc.WriteTo(buf)
+	notErr(t, err)
+	expected := "checksum sha256:1234\nname go\n\n# download urls\n[url \"linux\"]\namd64 https://localhost/linux-amd64.tgz\narm https://localhost/linux-arm.tgz\n\n[url \"darwin\"]\namd64 https://localhost/darwin-amd64.tgz\n\n[url \"windows\"]\namd64 https://localhost/windows-amd64.zip\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestSectionErrors(t *testing.T) {
+	for _, text := range []string{"[url\n", "[]\n", "[url linux]\n", "[url \"linux]\n", "[auth]\n[auth]\n"} {
+		if _, err := keyval.ParseString(text); err == nil {
+			t.Errorf("Expected a syntax error parsing %q", text)
+		} else if _, ok := err.(*keyval.SyntaxError); !ok {
+			t.Errorf("Expected a syntax error parsing %q, got %v", text, err)
+		}
+	}
+
+	// The same key may be set once in each section.
+	_, err := keyval.ParseString("key1 val1\n[a]\nkey1 val2\n")
+	notErr(t, err)
+}
@@ -0,0 +1,205 @@
+package keyval
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TagName is the struct tag read by Marshal and Unmarshal. A tag names the
+// key of a field and may add omitempty, as in `keyval:"name,omitempty"`.
+// Fields tagged "-" and unexported fields are skipped; untagged fields use
+// their own names.
+const TagName = "keyval"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// A field of a struct and the key it is stored under.
+type field struct {
+	key       string
+	index     int
+	omitEmpty bool
+}
+
+// List the fields of a struct type which have keys.
+func fields(t reflect.Type) []field {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get(TagName)
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		key := parts[0]
+		if key == "" {
+			key = f.Name
+		}
+
+		omitEmpty := false
+		for _, opt := range parts[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		fs = append(fs, field{key, i, omitEmpty})
+	}
+	return fs
+}
+
+// Find the struct a value points to, or is.
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return rv, fmt.Errorf("keyval: can not use %T, which is not a struct", v)
+	}
+	return rv, nil
+}
+
+// Marshal writes the fields of a struct as key-val pairs in the order they
+// are declared. Strings, ints, bools, durations and string slices are
+// supported; the elements of a slice are separated by spaces, so they may
+// not contain whitespace themselves.
+func Marshal(v interface{}) ([]byte, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := new(Document)
+	for _, f := range fields(rv.Type()) {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && isZero(fv) {
+			continue
+		}
+		val, err := formatValue(fv)
+		if err != nil {
+			return nil, fmt.Errorf("keyval: can not marshal %s: %s", f.key, err)
+		}
+		doc.Lines = append(doc.Lines, Line{Key: f.key, Val: val, Raw: formatLine(f.key, val), IsPair: true})
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = doc.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.Slice {
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func formatValue(v reflect.Value) (string, error) {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			break
+		}
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = v.Index(i).String()
+			if elems[i] == "" || strings.IndexFunc(elems[i], isSpace) >= 0 {
+				return "", fmt.Errorf("element %q is empty or contains whitespace", elems[i])
+			}
+		}
+		return strings.Join(elems, KeyValDelim), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// Unmarshal parses key-val pairs strictly and stores them in the fields of
+// the struct v points to; see Marshal for the supported types. Keys without
+// fields are ignored, and fields without keys are left as they are.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("keyval: Unmarshal needs a non-nil pointer")
+	}
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for _, f := range fields(rv.Type()) {
+		val, ok := cfg[f.key]
+		if !ok {
+			continue
+		}
+		if err := parseValue(val, rv.Field(f.index)); err != nil {
+			return fmt.Errorf("keyval: can not unmarshal %s: %s", f.key, err)
+		}
+	}
+	return nil
+}
+
+func parseValue(s string, v reflect.Value) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			break
+		}
+		elems := strings.Fields(s)
+		slice := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			slice.Index(i).SetString(elem)
+		}
+		v.Set(slice)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
@@ -0,0 +1,217 @@
+package keyval
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes a malformed line. Lines and columns count from 1;
+// columns count bytes.
+type SyntaxError struct {
+	File         string
+	Line, Column int
+	Msg          string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// Parser reads key-val documents. The zero Parser is strict: it rejects
+// duplicate keys and malformed quoted values. A lenient Parser accepts
+// them as older versions did, letting the last duplicate win and keeping
+// malformed quoted values as they are written.
+type Parser struct {
+	File    string // named in errors
+	Lenient bool
+}
+
+// ParseDocument reads a document. Lines which are blank or begin with # are
+// kept as they are. A value beginning with a double quote is unquoted with
+// Go's escapes. A line of the form [name] or [name "sub"] begins a section.
+func (p Parser) ParseDocument(r io.Reader) (*Document, error) {
+	doc := new(Document)
+	var section Section
+	seen := make(map[Section]map[string]int)
+	headers := make(map[Section]int)
+
+	// Read whole lines, however long. A \r before a \n is stripped.
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if raw == "" && err == io.EOF {
+			break
+		}
+		raw = strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+
+		line, col, msg := p.parseLine(raw)
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t")) + 1
+		if msg == "" && line.IsHeader {
+			if first, ok := headers[line.Section]; ok && !p.Lenient {
+				msg, col = fmt.Sprintf("duplicate section %s, first on line %d", line.Section, first), indent
+			}
+			headers[line.Section] = n
+			section = line.Section
+		}
+		if msg == "" && line.IsPair {
+			if seen[section] == nil {
+				seen[section] = make(map[string]int)
+			}
+			if first, ok := seen[section][line.Key]; ok && !p.Lenient {
+				msg, col = fmt.Sprintf("duplicate key %s, first set on line %d", line.Key, first), indent
+			}
+			seen[section][line.Key] = n
+		}
+		if msg != "" {
+			return nil, &SyntaxError{p.File, n, col, msg}
+		}
+		line.Section = section
+		doc.Lines = append(doc.Lines, line)
+
+		if err == io.EOF {
+			break
+		}
+	}
+	return doc, nil
+}
+
+// Parse reads the top of a document into a map, skipping comments and blank
+// lines; see Document to read sections.
+func (p Parser) Parse(r io.Reader) (map[string]string, error) {
+	doc, err := p.ParseDocument(r)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Map(), nil
+}
+
+// Split a line into a key and a value separated by spaces or tabs. Return
+// the column and message of any error.
+func (p Parser) parseLine(raw string) (line Line, col int, msg string) {
+	line.Raw = raw
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" || strings.HasPrefix(trimmed, CommentPrefix) {
+		return line, 0, ""
+	}
+	if strings.HasPrefix(trimmed, "[") {
+		section, offset, msg := parseHeader(trimmed)
+		if msg == "" {
+			line.Section, line.IsHeader = section, true
+			return line, 0, ""
+		}
+		if !p.Lenient {
+			return line, len(raw) - len(strings.TrimLeft(raw, " \t")) + offset + 1, msg
+		}
+	}
+
+	start := len(raw) - len(strings.TrimLeft(raw, " \t"))
+	end := strings.IndexAny(raw[start:], " \t")
+	if end < 0 {
+		end = len(raw)
+	} else {
+		end += start
+	}
+	valStart := len(raw) - len(strings.TrimLeft(raw[end:], " \t"))
+
+	line.Key, line.Val, line.IsPair = raw[start:end], raw[valStart:], true
+	if !strings.HasPrefix(line.Val, `"`) {
+		return line, 0, ""
+	}
+
+	val, offset, msg := unquote(line.Val)
+	if msg != "" {
+		if p.Lenient {
+			return line, 0, ""
+		}
+		return line, valStart + offset + 1, msg
+	}
+	line.Val = val
+	return line, 0, ""
+}
+
+// Parse a section header, [name] or [name "sub"]. Return the offset and
+// message of any error.
+func parseHeader(s string) (section Section, offset int, msg string) {
+	if !strings.HasSuffix(s, "]") {
+		return section, len(s), "section header must end with ]"
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	offset = strings.Index(s, inner)
+
+	end := strings.IndexAny(inner, " \t")
+	if end < 0 {
+		end = len(inner)
+	}
+	section.Name = inner[:end]
+	if section.Name == "" || strings.ContainsAny(section.Name, `"[]`) {
+		return section, offset, "section header needs a name"
+	}
+
+	sub := strings.TrimLeft(inner[end:], " \t")
+	if sub == "" {
+		return section, 0, ""
+	}
+	offset += len(inner) - len(sub)
+	if !strings.HasPrefix(sub, `"`) {
+		return section, offset, "section subname must be quoted"
+	}
+	val, subOffset, msg := unquote(sub)
+	if msg != "" {
+		return section, offset + subOffset, msg
+	}
+	section.Sub = val
+	return section, 0, ""
+}
+
+// Unquote a value beginning with a double quote. Only whitespace may follow
+// the closing quote. Return the offset and message of any error.
+func unquote(s string) (val string, offset int, msg string) {
+	end := -1
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '"' {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", 0, "unterminated quoted value"
+	}
+
+	rest := s[end+1:]
+	if trimmed := strings.TrimLeft(rest, " \t"); trimmed != "" {
+		return "", len(s) - len(trimmed), fmt.Sprintf("unexpected %s after quoted value", trimmed)
+	}
+
+	val, err := strconv.Unquote(s[:end+1])
+	if err != nil {
+		return "", 0, "invalid escape in quoted value"
+	}
+	return val, 0, ""
+}
+
+// Check if a value must be quoted to be read back as it was written.
+func needsQuote(val string) bool {
+	if val == "" {
+		return false
+	}
+	if strings.HasPrefix(val, `"`) || strings.TrimSpace(val) != val {
+		return true
+	}
+	for _, r := range val {
+		if r < ' ' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
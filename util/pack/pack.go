@@ -1,22 +1,90 @@
 package pack
 
 import (
+	"io/ioutil"
 	"os"
 
+	"github.com/skotchpine/xvm/util"
 	"github.com/skotchpine/xvm/util/keyval"
 )
 
+// Environment variables set by xvm when running a pack's pull executable.
+const (
+	EnvPath     = "XVM_PULL_PATH"
+	EnvVersion  = "XVM_PULL_VERSION"
+	EnvConfig   = "XVM_PULL_CONFIG"
+	EnvReceipt  = "XVM_PULL_RECEIPT"
+	EnvChecksum = "XVM_PULL_CHECKSUM"
+)
+
+// Keys of the receipt written by Record.
+const (
+	ReceiptSource   = "source"
+	ReceiptChecksum = "checksum"
+)
+
+// Ctx describes the version being pulled. Checksum is only set when a
+// lockfile expects a particular archive.
 type Ctx struct {
-	Path, Version string
-	Config        map[string]string
+	Path, Version     string
+	Receipt, Checksum string
+	Config            map[string]string
 }
 
 func Context() (ctx *Ctx, err error) {
 	ctx = new(Ctx)
 
-	ctx.Path = os.Getenv("XVM_PULL_PATH")
-	ctx.Version = os.Getenv("XVM_PULL_VERSION")
-	ctx.Config, err = keyval.ParseString(os.Getenv("XVM_PULL_CONFIG"))
+	ctx.Path = os.Getenv(EnvPath)
+	ctx.Version = os.Getenv(EnvVersion)
+	ctx.Receipt = os.Getenv(EnvReceipt)
+	ctx.Checksum = os.Getenv(EnvChecksum)
+	ctx.Config, err = keyval.ParseString(os.Getenv(EnvConfig))
 
 	return
 }
+
+// Unmarshal stores the config a version is pulled with in the fields of the
+// struct v points to; see keyval's Unmarshal.
+func (ctx *Ctx) Unmarshal(v interface{}) error {
+	reader, err := keyval.NewReader(ctx.Config)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return keyval.Unmarshal(data, v)
+}
+
+// Record tells xvm where a version was downloaded from and the checksum of
+// the downloaded archive, so they can be written to lockfiles. If a pack
+// records no checksum, xvm records one of the tree it installed.
+func (ctx *Ctx) Record(source, checksum string) error {
+	return (&Receipt{source, checksum}).Write(ctx.Receipt)
+}
+
+// Receipt is the record of where an installed version came from.
+type Receipt struct {
+	Source   string `keyval:"source,omitempty"`
+	Checksum string `keyval:"checksum,omitempty"`
+}
+
+// ReadReceipt reads a receipt file.
+func ReadReceipt(path string) (*Receipt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	receipt := new(Receipt)
+	return receipt, keyval.Unmarshal(data, receipt)
+}
+
+// Write replaces a receipt file.
+func (receipt *Receipt) Write(path string) error {
+	data, err := keyval.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, util.PermPublic)
+}
@@ -2,8 +2,10 @@ package pack_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/skotchpine/xvm/util"
 	"github.com/skotchpine/xvm/util/pack"
 )
 
@@ -14,9 +16,11 @@ func TestPackContext(t *testing.T) {
 	}
 
 	env := map[string]string{
-		"XVM_PULL_PATH":    "qwer",
-		"XVM_PULL_CONFIG":  "",
-		"XVM_PULL_VERSION": "zxcv",
+		"XVM_PULL_PATH":     "qwer",
+		"XVM_PULL_CONFIG":   "",
+		"XVM_PULL_VERSION":  "zxcv",
+		"XVM_PULL_RECEIPT":  "asdf",
+		"XVM_PULL_CHECKSUM": "sha256:1234",
 	}
 
 	for key, val := range config {
@@ -40,6 +44,12 @@ func TestPackContext(t *testing.T) {
 	if ctx.Version != env["XVM_PULL_VERSION"] {
 		t.Error("Failed to get XVM_PULL_VERSION from the environment")
 	}
+	if ctx.Receipt != env["XVM_PULL_RECEIPT"] {
+		t.Error("Failed to get XVM_PULL_RECEIPT from the environment")
+	}
+	if ctx.Checksum != env["XVM_PULL_CHECKSUM"] {
+		t.Error("Failed to get XVM_PULL_CHECKSUM from the environment")
+	}
 
 	for key, expected := range config {
 		if actual, ok := ctx.Config[key]; !ok {
@@ -49,3 +59,38 @@ func TestPackContext(t *testing.T) {
 		}
 	}
 }
+
+func TestRecord(t *testing.T) {
+	ctx := &pack.Ctx{Receipt: filepath.Join(os.TempDir(), "xvm-test-receipt")}
+	defer os.Remove(ctx.Receipt)
+
+	if err := ctx.Record("https://localhost/pack.tgz", "sha256:1234"); err != nil {
+		t.Error(err)
+	}
+
+	receipt, err := util.ReadMap(ctx.Receipt)
+	if err != nil {
+		t.Error(err)
+	}
+	if source := receipt[pack.ReceiptSource]; source != "https://localhost/pack.tgz" {
+		t.Errorf("Expected source https://localhost/pack.tgz, got %s", source)
+	}
+	if checksum := receipt[pack.ReceiptChecksum]; checksum != "sha256:1234" {
+		t.Errorf("Expected checksum sha256:1234, got %s", checksum)
+	}
+}
+
+func TestUnmarshalConfig(t *testing.T) {
+	ctx := &pack.Ctx{Config: map[string]string{"arch": "arm", "cgo": "true", "extra": "ignored"}}
+
+	var config struct {
+		Arch string `keyval:"arch"`
+		Cgo  bool   `keyval:"cgo"`
+	}
+	if err := ctx.Unmarshal(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Arch != "arm" || !config.Cgo {
+		t.Errorf("Expected arch arm with cgo, got %+v", config)
+	}
+}
@@ -0,0 +1,12 @@
+// +build !windows
+
+package util
+
+import "syscall"
+
+// Check if a process is running. A process owned by another user is
+// running too.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
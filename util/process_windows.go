@@ -0,0 +1,15 @@
+// +build windows
+
+package util
+
+import "os"
+
+// Check if a process is running. Windows can only find running processes.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
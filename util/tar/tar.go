@@ -4,10 +4,12 @@ package tar
 import (
 	"archive/tar"
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 // Archive creates an archived byte slice from the path of a directory.
@@ -88,6 +90,7 @@ func archiveWriteEntries(archive *tar.Writer, abs, rel string) error {
 // Unarchive creates a new directory inside abs with the contents of archive,
 // because Archive writes files relative to and including a root directory.
 // Any paths which conflict may be truncated or have their permission bits reset.
+// Only directories and regular files are restored; other entries are skipped.
 //
 // Forward errors from operating system queries, input/output and archive operations.
 func Unarchive(abs string, src io.Reader) error {
@@ -103,18 +106,27 @@ func Unarchive(abs string, src io.Reader) error {
 		}
 		info := header.FileInfo()
 
+		// Refuse entries which would be written outside of abs.
+		a := filepath.Join(abs, header.Name)
+		if rel, err := filepath.Rel(abs, a); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("tar: entry %s is outside of %s", header.Name, abs)
+		}
+
 		// Skip after creation if the file is a directory.
 		if info.IsDir() {
-			a := filepath.Join(abs, header.Name)
 			if err = os.MkdirAll(a, info.Mode()); err != nil {
 				return err
 			}
 			continue
 		}
 
-		// Get the file handle for all non-directories, and overwrite content.
+		// Skip links, devices and extended headers such as those written by git's archive.
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Get the file handle for all regular files, and overwrite content.
 		mask := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
-		a := filepath.Join(abs, header.Name)
 		file, err := os.OpenFile(a, mask, info.Mode())
 		if err == nil {
 			_, err = io.Copy(file, archive)
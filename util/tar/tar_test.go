@@ -1,6 +1,8 @@
 package tar_test
 
 import (
+	archivetar "archive/tar"
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -46,3 +48,28 @@ func TestArchive(t *testing.T) {
 		t.Errorf("The package directory %s was not restored from archive. Err: %s", path, err)
 	}
 }
+
+func TestUnarchiveOutside(t *testing.T) {
+	root := filepath.Join(os.TempDir(), "xvm-unarchive-outside-test")
+	defer os.RemoveAll(root)
+
+	buf := new(bytes.Buffer)
+	archive := archivetar.NewWriter(buf)
+	header := &archivetar.Header{Name: "../escaped", Mode: 0666, Size: 4, Typeflag: archivetar.TypeReg}
+	if err := archive.WriteHeader(header); err != nil {
+		t.Error(err)
+	}
+	if _, err := archive.Write([]byte("test")); err != nil {
+		t.Error(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err := tar.Unarchive(root, buf); err == nil {
+		t.Error("Expected an error unarchiving an entry outside of the destination")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped")); err == nil {
+		t.Error("An entry outside of the destination was written")
+	}
+}
@@ -1,9 +1,16 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/skotchpine/xvm/util/keyval"
 )
@@ -24,26 +31,54 @@ func DirNames(path string) ([]string, error) {
 }
 
 // Aggregate errors from reading a key-val map from file with keyval's Read.
+// Files are parsed leniently, as xvm always has, so files written by hand
+// or by older versions still load; see CheckMap.
 func ReadMap(path string) (conf map[string]string, err error) {
 	var file *os.File
 	if file, err = os.Open(path); err == nil {
-		conf, err = keyval.Parse(file)
+		conf, err = keyval.Parser{File: path, Lenient: true}.Parse(file)
+		file.Close()
 	}
 	return
 }
 
-// Aggregate errors from writing a key-val map to file with keyval's Write.
+// CheckMap parses a key-val file strictly, returning the first
+// *keyval.SyntaxError which ReadMap overlooks.
+func CheckMap(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = keyval.Parser{File: path}.ParseDocument(file)
+	return err
+}
+
+// Aggregate errors from writing a key-val map to file. If the file exists,
+// its comments, blank lines and the order of its keys are kept, and new
+// keys are inserted in sorted order; see keyval's Document.
 func WriteMap(path string, conf map[string]string) error {
+	doc := new(keyval.Document)
+	if file, err := os.Open(path); err == nil {
+		doc, err = keyval.Parser{File: path, Lenient: true}.ParseDocument(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	doc.Update(conf)
+
 	file, err := os.OpenFile(path, ModeClobber, PermPublic)
 	if err != nil {
 		return err
 	}
-
-	reader, err := keyval.NewReader(conf)
-	if err == nil {
-		_, err = io.Copy(file, reader)
+	if _, err = doc.WriteTo(file); err != nil {
+		file.Close()
+		return err
 	}
-	return err
+	return file.Close()
 }
 
 // Check if a file exists, discarding os's FileInfo.
@@ -54,8 +89,143 @@ func NotExist(path string) bool {
 
 // Execute a command, printing to stdout and stderr.
 func Cmd(path string, arg ...string) error {
+	return CmdEnv(nil, path, arg...)
+}
+
+// Execute a command with extra environment variables, printing to stdout and stderr.
+func CmdEnv(env []string, path string, arg ...string) error {
+	return CmdTo(os.Stdout, os.Stderr, env, path, arg...)
+}
+
+// Execute a command with extra environment variables, writing to stdout and stderr.
+func CmdTo(stdout, stderr io.Writer, env []string, path string, arg ...string) error {
+	c := exec.Command(path, arg...)
+	c.Env = append(os.Environ(), env...)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
+
+// Execute a command, capturing stdout and printing to stderr.
+func Output(path string, arg ...string) ([]byte, error) {
+	c := exec.Command(path, arg...)
+	c.Stderr = os.Stderr
+	return c.Output()
+}
+
+// Checksum hashes the relative paths, permissions and contents of every
+// file below root, in order, so identical trees hash identically on any
+// machine. Forward errors from walking and reading the tree.
+func Checksum(root string) (string, error) {
+	return ChecksumUntil(root, time.Time{})
+}
+
+// ChecksumUntil hashes a tree as Checksum does, but skips files modified
+// after until, such as caches a tool writes once it is installed. A zero
+// until hashes every file.
+func ChecksumUntil(root string, until time.Time) (string, error) {
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && (until.IsZero() || !info.ModTime().After(until)) {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(hash, filepath.ToSlash(rel)+"\x00"+info.Mode().Perm().String()+"\x00")
+
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Execute a command connected to stdin, stdout and stderr, returning its exit
+// status. Errors are only returned if the command could not be run.
+func Exec(path string, arg ...string) (int, error) {
 	c := exec.Command(path, arg...)
+	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
-	return c.Run()
+
+	err := c.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// Lock creates a lock file holding the ID of this process, waiting while
+// another process holds it. A lock is broken if the process which holds it
+// has exited, or if it is older than stale. If it has to wait, Lock calls
+// wait once first. Call the returned function to unlock.
+func Lock(path string, stale time.Duration, wait func()) (unlock func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), PermPublic); err != nil {
+		return nil, err
+	}
+	for waited := false; ; waited = true {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, PermPublic)
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if abandoned(path, stale) {
+			os.Remove(path)
+			continue
+		}
+		if !waited && wait != nil {
+			wait()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Check if a lock file is older than stale or held by a process which has
+// exited. A lock which is still being written is not abandoned.
+func abandoned(path string, stale time.Duration) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) > stale {
+		return true
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(string(content))
+	return err == nil && !processAlive(pid)
 }
@@ -3,10 +3,14 @@ package util_test
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/keyval"
 )
 
 func TestDirNames(t *testing.T) {
@@ -73,6 +77,55 @@ func TestMap(t *testing.T) {
 	}
 }
 
+func TestMapLenient(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "xvm-test-map-lenient")
+	defer os.Remove(path)
+
+	if err := ioutil.WriteFile(path, []byte("go 1.8\ngo 1.9\n[node\n"), util.PermPublic); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := util.ReadMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual["go"] != "1.9" || len(actual) != 2 {
+		t.Errorf("Expected the last duplicate and the malformed line to be read, got %v", actual)
+	}
+	if err := util.WriteMap(path, map[string]string{"go": "1.10"}); err != nil {
+		t.Fatal(err)
+	}
+	if actual, _ := util.ReadMap(path); actual["go"] != "1.10" {
+		t.Errorf("Expected go to be rewritten, got %v", actual)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("go 1.8\ngo 1.9\n"), util.PermPublic); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := util.CheckMap(path).(*keyval.SyntaxError); !ok {
+		t.Error("Expected CheckMap to report the duplicate key")
+	}
+}
+
+func TestWriteMapKeepsComments(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "xvm-test-map-comments")
+	defer os.Remove(path)
+
+	if err := ioutil.WriteFile(path, []byte("# tools\n\ngo 1.9\nnode 8\n"), util.PermPublic); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteMap(path, map[string]string{"go": "1.10", "node": "8", "java": "9"}); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# tools\n\ngo 1.10\njava 9\nnode 8\n"; string(actual) != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
+
 func TestNotExist(t *testing.T) {
 	path := filepath.Join(os.TempDir(), "xvm-test-dir-not-exist")
 
@@ -89,6 +142,117 @@ func TestNotExist(t *testing.T) {
 	}
 }
 
+func TestChecksum(t *testing.T) {
+	root := filepath.Join(os.TempDir(), "xvm-checksum-test")
+	defer os.RemoveAll(root)
+
+	write := func(dir, content string) string {
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), util.PermPublic); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "bin", "x"), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := util.Checksum(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		return sum
+	}
+
+	a := write(filepath.Join(root, "a"), "x")
+	b := write(filepath.Join(root, "b"), "x")
+	c := write(filepath.Join(root, "c"), "y")
+	if a != b {
+		t.Errorf("Expected identical trees to have identical checksums, got %s and %s", a, b)
+	}
+	if a == c {
+		t.Error("Expected different trees to have different checksums")
+	}
+
+	// Files modified after the cutoff are skipped.
+	dir := filepath.Join(root, "a")
+	until := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "bin", "x"), until, until); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cache"), []byte("z"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := util.ChecksumUntil(dir, until); err != nil || sum != a {
+		t.Errorf("Expected a file written after the cutoff to be skipped, got %s %v", sum, err)
+	}
+	if sum, _ := util.Checksum(dir); sum == a {
+		t.Error("Expected Checksum to hash every file")
+	}
+}
+
 func TestCmd(t *testing.T) {
 	t.Skip()
 }
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "xvm-lock-test", "lock")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	unlock, err := util.Lock(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan bool)
+	waits := 0
+	go func() {
+		unlock, err := util.Lock(path, time.Hour, func() { waits++ })
+		if err != nil {
+			t.Error(err)
+			close(locked)
+			return
+		}
+		locked <- true
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the second lock to wait")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	<-locked
+	if waits != 1 {
+		t.Errorf("Expected to be told of waiting once, got %d", waits)
+	}
+
+	// A lock older than stale is broken.
+	if _, err := util.Lock(path, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := util.Lock(path, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// So is a lock held by a process which has exited.
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(exited.Process.Pid)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := util.Lock(path, time.Hour, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the lock of an exited process to be broken")
+	}
+}
@@ -0,0 +1,224 @@
+// Package version compares version strings in the styles packs commonly
+// use: semver (v1.2.3-rc.1+build), go (go1.9rc2) and dates (2017-10-26).
+package version
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of version, in order.
+const (
+	KindPrerelease = iota - 1
+	KindRelease
+	KindPostrelease
+)
+
+// Words which begin a suffix released after the version they follow, such as
+// 7.5p1. Any other suffix is a prerelease.
+var postWords = map[string]bool{
+	"p": true, "patch": true, "pl": true, "post": true, "r": true, "rev": true, "u": true, "update": true,
+}
+
+// Ranks of words in prerelease suffixes. Unknown words rank after these.
+var preRanks = map[string]int{
+	"dev": 0, "snapshot": 0, "nightly": 0,
+	"a": 1, "alpha": 1,
+	"b": 2, "beta": 2,
+	"m": 3, "pre": 3, "preview": 3,
+	"c": 4, "rc": 4,
+}
+
+// Version is a version string split into comparable parts.
+type Version struct {
+	Original string
+	Release  []int    // numeric segments, e.g. 1 9 2 of go1.9.2
+	Kind     int      // KindPrerelease, KindRelease or KindPostrelease
+	Suffix   []string // words and numbers after the release, e.g. rc 2 of go1.9rc2
+}
+
+// Parse splits a version string. Any leading letters, such as v or go, and
+// any build metadata after + are ignored. Dots, dashes or underscores
+// between numbers separate release segments, so dates are releases.
+func Parse(s string) Version {
+	v := Version{Original: s}
+
+	rest := strings.TrimLeftFunc(s, func(r rune) bool { return !isDigit(r) })
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		rest = rest[:i]
+	}
+
+	// Consume numbers separated by the first separator used between them, so
+	// the dash of 1.2.3-1 starts a suffix but those of 2017-10-26 do not.
+	var sep byte
+	for {
+		n := strings.IndexFunc(rest, func(r rune) bool { return !isDigit(r) })
+		if n < 0 {
+			n = len(rest)
+		}
+		if n == 0 {
+			break
+		}
+		segment, _ := strconv.Atoi(rest[:n])
+		v.Release = append(v.Release, segment)
+		rest = rest[n:]
+
+		if len(rest) < 2 || !isSeparator(rune(rest[0])) || !isDigit(rune(rest[1])) {
+			break
+		}
+		if sep == 0 {
+			sep = rest[0]
+		} else if rest[0] != sep {
+			break
+		}
+		rest = rest[1:]
+	}
+
+	v.Suffix = split(rest)
+	if len(v.Suffix) > 0 {
+		v.Kind = KindPrerelease
+		if postWords[strings.ToLower(v.Suffix[0])] {
+			v.Kind = KindPostrelease
+		}
+	}
+	return v
+}
+
+// Split a suffix into words and numbers, dropping separators.
+func split(s string) (parts []string) {
+	start := -1
+	for i, r := range s {
+		if isSeparator(r) {
+			if start >= 0 {
+				parts = append(parts, s[start:i])
+			}
+			start = -1
+			continue
+		}
+		if start >= 0 && isDigit(r) != isDigit(rune(s[start])) {
+			parts = append(parts, s[start:i])
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isSeparator(r rune) bool {
+	return r == '.' || r == '-' || r == '_'
+}
+
+// Compare returns -1, 0 or 1 as a is older than, the same as, or newer than
+// b. Missing release segments count as zero, so go1.9 and 1.9.0 compare as
+// the same version; Less breaks such ties.
+func (a Version) Compare(b Version) int {
+	for i := 0; i < len(a.Release) || i < len(b.Release); i++ {
+		if c := compareInts(segment(a.Release, i), segment(b.Release, i)); c != 0 {
+			return c
+		}
+	}
+	if c := compareInts(a.Kind, b.Kind); c != 0 {
+		return c
+	}
+
+	for i := 0; i < len(a.Suffix) && i < len(b.Suffix); i++ {
+		if c := compareParts(a.Suffix[i], b.Suffix[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a.Suffix), len(b.Suffix))
+}
+
+func segment(release []int, i int) int {
+	if i < len(release) {
+		return release[i]
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare parts of suffixes. Numbers are older than words, as in semver.
+func compareParts(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if c := compareInts(rank(a), rank(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func rank(word string) int {
+	if r, ok := preRanks[word]; ok {
+		return r
+	}
+	return len(preRanks)
+}
+
+// Compare parses and compares two version strings.
+func Compare(a, b string) int {
+	return Parse(a).Compare(Parse(b))
+}
+
+// Less orders two version strings, oldest first. Versions which compare as
+// the same are ordered by their strings, so sorting is deterministic.
+func Less(a, b string) bool {
+	if c := Compare(a, b); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// Sort orders version strings, oldest first.
+func Sort(versions []string) {
+	sort.Slice(versions, func(i, j int) bool { return Less(versions[i], versions[j]) })
+}
+
+// IsPrerelease reports whether a version string has a prerelease suffix.
+func IsPrerelease(s string) bool {
+	return Parse(s).Kind == KindPrerelease
+}
+
+// Latest finds the newest version, skipping prereleases if stable is set.
+// Return false if there is no such version.
+func Latest(versions []string, stable bool) (string, bool) {
+	var latest string
+	found := false
+	for _, v := range versions {
+		if stable && IsPrerelease(v) {
+			continue
+		}
+		if !found || Less(latest, v) {
+			latest, found = v, true
+		}
+	}
+	return latest, found
+}
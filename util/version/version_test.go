@@ -0,0 +1,72 @@
+package version_test
+
+import (
+	"testing"
+
+	"github.com/skotchpine/xvm/util/version"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"1.10", "1.9", 1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.3-rc.1", "1.2.3", -1},
+		{"1.2.3-alpha", "1.2.3-beta", -1},
+		{"1.2.3-rc.2", "1.2.3-rc.10", -1},
+		{"1.2.3-1", "1.2.3-alpha", -1},
+		{"go1.9", "go1.9.0", 0},
+		{"go1.9rc2", "go1.9", -1},
+		{"go1.9beta1", "go1.9rc1", -1},
+		{"go1.9rc2", "go1.9rc10", -1},
+		{"go1.10beta1", "go1.9.4", 1},
+		{"2017-10-26", "2017-09-30", 1},
+		{"20171026", "20170930", 1},
+		{"2017.10.26", "2017-10-26", 0},
+		{"2017-10-26-beta", "2017-10-26", -1},
+		{"7.5p1", "7.5", 1},
+		{"7.5p1", "7.5p2", -1},
+		{"1.0-dev", "1.0-alpha", -1},
+	}
+
+	for _, test := range tests {
+		if actual := version.Compare(test.a, test.b); actual != test.expected {
+			t.Errorf("Expected %s compared to %s to be %d, got %d", test.a, test.b, test.expected, actual)
+		}
+		if actual := version.Compare(test.b, test.a); actual != -test.expected {
+			t.Errorf("Expected %s compared to %s to be %d, got %d", test.b, test.a, -test.expected, actual)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	versions := []string{"go1.10", "go1.9rc2", "go1.9.2", "go1.9", "go1.10beta1", "go1.8.5"}
+	expected := []string{"go1.8.5", "go1.9rc2", "go1.9", "go1.9.2", "go1.10beta1", "go1.10"}
+
+	version.Sort(versions)
+	for i := range expected {
+		if versions[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, versions)
+			break
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	versions := []string{"1.8.5", "1.9.2", "1.10rc1"}
+
+	if latest, ok := version.Latest(versions, false); !ok || latest != "1.10rc1" {
+		t.Errorf("Expected latest 1.10rc1, got %s", latest)
+	}
+	if stable, ok := version.Latest(versions, true); !ok || stable != "1.9.2" {
+		t.Errorf("Expected stable 1.9.2, got %s", stable)
+	}
+	if _, ok := version.Latest([]string{"1.0rc1"}, true); ok {
+		t.Error("Expected no stable version among prereleases")
+	}
+}
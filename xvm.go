@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/skotchpine/xvm/util"
+	"github.com/skotchpine/xvm/util/version"
 )
 
 // group specification options
@@ -16,28 +20,50 @@ const (
 xvm version
 xvm usage
 xvm help
+xvm <command> [<args>] --dry-run
+xvm <external> [<args>]
 
 xvm init
+xvm doctor
 xvm which  [<pack>] [local|global]
 xvm status [<pack>] [local|global]
 xvm remove
+xvm prune [--remove]
 
 xvm installed <pack>
-xvm available <pack>
+xvm available <pack> [--remote]
 xvm stable    <pack>
 xvm latest    <pack>
 
 xvm set   <pack> <version> [local|global]
 xvm unset <pack>           [local|global]
 
+xvm update  [<pack>]
+xvm install [--jobs <n>]
 xvm pull <pack> <version>
 xvm push <pack> <version>
-xvm drop <pack> <version>
+xvm drop <pack> <version> [--force]
 
-xvm config <pack> <version>
+xvm config list  <pack>                 [<version>]
+xvm config get   <pack> <key>           [<version>]
+xvm config set   <pack> <key> <value>   [<version>]
+xvm config unset <pack> <key>           [<version>]
 
-xvm alias   <pack> <version> <name>
-xvm unalias <pack> <name>`
+xvm lock [--check]
+
+xvm history
+xvm undo
+
+xvm export --format <tool-versions|nvmrc|go-version|json>
+xvm import <file> [--format <format>]
+
+xvm pack add    <name> <source> [<ref>]
+xvm pack list
+xvm pack update [<name>]
+xvm pack remove <name> [--force]
+
+xvm alias   <pack> <version> <name> [local]
+xvm unalias <pack> <name>           [local]`
 
 	StrGlobal = "global"
 	StrLocal  = "local"
@@ -47,9 +73,25 @@ xvm unalias <pack> <name>`
 	StrVersions  = "versions"
 	StrInstalled = "installed"
 	StrAvailable = "available"
+	StrRemote    = "available.remote"
 	StrAliases   = "aliases"
+	StrGroups    = "groups"
+	StrLock      = "versions.lock"
+	StrReceipts  = "receipts"
+	StrIndex     = "index"
 	StrBin       = "bin"
+	StrSource    = "source"
+	StrRef       = "ref"
+	StrVersion   = "version"
+	StrInfo      = "info"
+	StrPull      = "pull"
+	StrList      = "list"
 	StrSplat     = "*"
+	StrConfig    = "config"
+
+	StrLatest     = "latest"
+	StrStable     = "stable"
+	StrPrerelease = "prerelease"
 )
 
 var (
@@ -57,14 +99,22 @@ var (
 	LocalDirPath, LocalGroupPath   string
 	PWD                            string
 
+	// GroupPaths lists every group which applies to the working directory,
+	// nearest first and ending with the global group.
+	GroupPaths []string
+
 	installedMap map[string][]string
 	availableMap map[string][]string
+	preMap       map[string]map[string]bool // available versions flagged as prereleases
 	binMap       map[string]string
 	aliasesMap   map[string]map[string]string
 
-	localMap   map[string]string
-	globalMap  map[string]string
-	currentMap map[string]string
+	groupMaps       []map[string]string
+	groupAliasesMap map[string]map[string]string // pack to alias to version, nearest group first
+	localMap        map[string]string
+	globalMap       map[string]string
+	currentMap      map[string]string
+	sourceMap       map[string]string
 )
 
 func warn(msg string, etc ...interface{}) {
@@ -73,7 +123,26 @@ func warn(msg string, etc ...interface{}) {
 
 func fail(msg string, etc ...interface{}) {
 	warn(msg, etc...)
-	os.Exit(1)
+	exit(1)
+}
+
+// exit journals whatever a command changed before it failed, then exits.
+func exit(code int) {
+	if err := FinishEntry(); err != nil {
+		warn("Failed to update journal: %s", err)
+	}
+	os.Exit(code)
+}
+
+// confirm asks a yes or no question on stderr; anything but yes is no.
+func confirm(msg string, etc ...interface{}) bool {
+	fmt.Fprintf(os.Stderr, msg+" [y/N] ", etc...)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
 }
 
 // FindGlobalGroup uses XVMPATH as the global group. If XVMPATH is not set,
@@ -94,212 +163,124 @@ func FindGlobalGroup() (group, dir string) {
 // FindLocalGroup sets the nearest group. If none exist between the
 // working directory and the root, use the global group.
 func FindLocalGroup() (group, dir string) {
-	group = GlobalGroupPath
+	group = FindGroups()[0]
+	return group, filepath.Dir(group)
+}
 
+// FindGroups lists every group between the working directory and the root,
+// nearest first, and always ends with the global group.
+func FindGroups() (groups []string) {
 	var err error
 	PWD, err = os.Getwd()
 	if err != nil {
 		warn("Failed to get working directory")
-		goto returnLocalGroup
+		return []string{GlobalGroupPath}
 	}
 
 	// Move from the current directory to the root; stop before crossing the global path.
-	for x := PWD; x != "/" && x != GlobalDirPath; x = filepath.Dir(x) {
-		// If a group is found (xvm directory exists), it is the local group.
+	for x := PWD; x != GlobalDirPath; x = filepath.Dir(x) {
+		// Every group found (xvm directory exists) is inherited by those below it.
 		info, err := os.Stat(filepath.Join(x, OSDir))
 		if err == nil && info.IsDir() {
-			group = filepath.Join(x, OSDir)
+			groups = append(groups, filepath.Join(x, OSDir))
+		}
+		if filepath.Dir(x) == x {
 			break
 		}
 	}
 
-returnLocalGroup:
-	return group, filepath.Dir(group)
+	return append(groups, GlobalGroupPath)
 }
 
-// MapGroup adds a group's versions to self and shared version maps.
-// Do not overwrite existing entries in the shared map.
-func MapGroup(self, shared map[string]string, groupPath string) {
-	versions, err := util.ReadMap(filepath.Join(groupPath, StrVersions))
+// ResolveAlias gets a concrete version name, following chains of aliases
+// of the groups and the pack. An alias in a cycle is returned unresolved.
+func ResolveAlias(pack, alias string) (concrete string) {
+	concrete, err := FollowAlias(Aliases(pack), alias)
 	if err != nil {
 		warn(err.Error())
-		return
+		return alias
 	}
-
-	for pack, version := range versions {
-		// Write each version to this group's map.
-		self[pack] = version
-
-		// Write each version to the shared map only if no entry exists.
-		if _, ok := shared[pack]; !ok {
-			shared[pack] = version
+	if concrete == StrLatest || concrete == StrStable {
+		if computed, ok := ComputeAlias(pack, concrete); ok {
+			return computed
 		}
 	}
+	return concrete
 }
 
-// MapGroups maps the versions of the local and global group.
-// Give the local group precedence.
-func MapGroups(done chan bool) {
-	MapGroup(localMap, currentMap, LocalGroupPath)
-	MapGroup(globalMap, currentMap, GlobalGroupPath)
-	done <- true
-}
-
-// MapInstalled maps the installed versions of all packages.
-func MapInstalled(done chan bool) {
-	defer func() { done <- true }()
-
-	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrInstalled, StrSplat)
-	list, err := filepath.Glob(glob)
-	if err != nil {
-		warn("Can not find installed versions")
-		return
-	}
-
-	for _, path := range list {
-		version := filepath.Base(path)
-		pack := filepath.Base(filepath.Dir(filepath.Dir(path)))
-		installedMap[pack] = append(installedMap[pack], version)
-	}
-}
-
-// MapBin maps the executables for installed versions of all packages.
-func MapBin(done chan bool) {
-	defer func() { done <- true }()
-
-	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrInstalled, StrSplat, StrBin, StrSplat)
-	list, err := filepath.Glob(glob)
-	if err != nil {
-		warn("Can not find executable versions")
-		return
-	}
-
-	for _, path := range list {
-		dir := filepath.Dir
-		pack := filepath.Base(dir(dir(dir(dir(path)))))
-		binMap[pack] = path
+// ComputeAlias finds the newest available version of a pack for latest, or
+// the newest which is not a prerelease for stable. Installed versions are
+// used if none are available.
+func ComputeAlias(pack, alias string) (string, bool) {
+	requireWarn(NeedAvailable | NeedInstalled)
+	versions := availableMap[pack]
+	if len(versions) == 0 {
+		versions = installedMap[pack]
+	}
+
+	if alias == StrStable {
+		var stable []string
+		for _, v := range versions {
+			if !preMap[pack][v] {
+				stable = append(stable, v)
+			}
+		}
+		versions = stable
 	}
+	return version.Latest(versions, alias == StrStable)
 }
 
-// MapAvailable maps the available versions of all packages.
-func MapAvailable(done chan bool) {
-	defer func() { done <- true }()
-
-	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrAvailable)
-	list, err := filepath.Glob(glob)
-	if err != nil {
-		warn("Can not find available versions")
-		return
-	}
-
-	for _, path := range list {
-		pack := filepath.Base(filepath.Dir(path))
-
-		versions, err := util.ReadMap(path)
-		if err != nil {
-			warn("Can not find available versions for %s", pack)
-			break
-		}
-
-		for version := range versions {
-			availableMap[pack] = append(availableMap[pack], version)
+// WrapBin executes an executable installed with one of the current versions,
+// passing on arguments and exiting with its status.
+func WrapBin(bin string) {
+	path, err := ResolveBin(bin)
+	if missing, ok := err.(*MissingError); ok && offerPull(missing) {
+		if err = AutoPull(missing.Pack, missing.Version); err == nil {
+			path, err = ResolveBin(bin)
 		}
 	}
-}
-
-// MapAliases maps the aliases for all packages.
-func MapAliases(done chan bool) {
-	defer func() { done <- true }()
-
-	glob := filepath.Join(GlobalGroupPath, StrPacks, StrSplat, StrAliases)
-	list, err := filepath.Glob(glob)
 	if err != nil {
-		warn("Can not find aliases")
-		return
-	}
-
-	for _, path := range list {
-		pack := filepath.Base(filepath.Dir(path))
-
-		aliases, err := util.ReadMap(path)
-		if err != nil {
-			warn("Can not find aliases for %s", pack)
-			break
-		}
-
-		aliasesMap[pack] = aliases
+		fail(err.Error())
 	}
-}
 
-// ResolveAlias gets a concrete version name.
-func ResolveAlias(pack, alias string) (concrete string) {
-	if aliases, ok := aliasesMap[pack]; ok {
-		if concrete, ok := aliases[alias]; ok {
-			return concrete
-		}
+	code, err := util.Exec(path, os.Args[1:]...)
+	if err != nil {
+		fail(err.Error())
 	}
-	return alias
+	os.Exit(code)
 }
 
-// WrapBin executes an executable installed with one of the current versions.
-func WrapBin(bin string) {
-	var pack, version string
-	var ok bool
-
-	if pack, ok = binMap[bin]; !ok {
-		fail("Failed to find binary %s", bin)
-	}
-	if version, ok = currentMap[pack]; !ok {
-		fail("No version set for package %s", pack)
-	}
-
-	path := filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version, StrBin, bin)
-	if util.NotExist(path) {
-		fail("No executable %s for version %s of %s", bin, version, pack)
-	}
-	if util.Cmd(path) != nil {
-		fail("")
+// Setup finds and loads every group and pack.
+func Setup() {
+	SetupGroups()
+	if err := Load(context.Background(), NeedAll); err != nil {
+		warn(err.Error())
 	}
 }
 
-func Setup() {
+// SetupGroups finds the global group and every group which applies to the
+// working directory, without loading them.
+func SetupGroups() {
 	GlobalGroupPath, GlobalDirPath = FindGlobalGroup()
-	LocalGroupPath, LocalDirPath = FindLocalGroup()
-
-	done := make(chan bool)
-
-	localMap = make(map[string]string)
-	globalMap = make(map[string]string)
-	currentMap = make(map[string]string)
-	go MapGroups(done)
-
-	availableMap = make(map[string][]string)
-	go MapAvailable(done)
-
-	installedMap = make(map[string][]string)
-	go MapInstalled(done)
-
-	binMap = make(map[string]string)
-	go MapBin(done)
-
-	aliasesMap = make(map[string]map[string]string)
-	go MapAliases(done)
-
-	for i := 0; i < 4; i++ {
-		<-done
-	}
+	GroupPaths = FindGroups()
+	LocalGroupPath = GroupPaths[0]
+	LocalDirPath = filepath.Dir(LocalGroupPath)
 }
 
 func main() {
-	Setup()
-
 	// If the name of this file isn't xvm,
-	// find a relevant binary and execute it
+	// find a relevant binary and execute it.
+	// Shims only need the current versions; the rest comes from the index.
 	name := filepath.Base(os.Args[0])
 	if name != "xvm"+OSExt {
+		SetupGroups()
+		if err := Load(context.Background(), NeedGroups); err != nil {
+			warn(err.Error())
+		}
 		WrapBin(name)
 	}
 
+	parseDryRun()
 	if len(os.Args) < 2 {
 		os.Args = append(os.Args, "usage")
 	}
@@ -308,62 +289,103 @@ func main() {
 	case "version":
 		fmt.Println(Version)
 	case "init":
-		argWrap(2, 2, initCmd)
+		argWrap(2, 2, NeedPaths, journaled(initCmd))
+	case "doctor":
+		argWrap(2, 2, NeedPaths, doctorCmd)
 	case "which":
-		argWrap(2, 4, whichCmd)
+		argWrap(2, 4, NeedGroups, whichCmd)
 	case "current":
-		argWrap(2, 4, currentCmd)
+		argWrap(2, 4, NeedGroups, currentCmd)
 	case "remove":
-		argWrap(2, 2, removeCmd)
+		argWrap(2, 2, NeedPaths, journaled(removeCmd))
+	case "prune":
+		argWrap(2, 3, NeedInstalled|NeedAliases, journaled(pruneCmd))
 	case "installed":
-		argWrap(3, 3, installedCmd)
+		argWrap(3, 3, NeedInstalled, installedCmd)
 	case "available":
-		argWrap(3, 3, availableCmd)
+		argWrap(3, 4, NeedGroups|NeedAvailable|NeedAliases, availableCmd)
+	case "alias":
+		argWrap(5, 6, NeedGroups|NeedInstalled|NeedAvailable|NeedAliases, journaled(aliasCmd))
+	case "unalias":
+		argWrap(4, 5, NeedPaths, journaled(unaliasCmd))
 	case "stable":
-		argWrap(3, 3, stableCmd)
+		argWrap(3, 3, NeedAvailable|NeedInstalled|NeedAliases, stableCmd)
 	case "latest":
-		argWrap(3, 3, latestCmd)
+		argWrap(3, 3, NeedAvailable|NeedInstalled|NeedAliases, latestCmd)
 	case "set":
-		argWrap(4, 5, setCmd)
+		argWrap(4, 5, NeedGroups|NeedInstalled|NeedAliases, journaled(setCmd))
 	case "unset":
-		argWrap(3, 4, unsetCmd)
+		argWrap(3, 4, NeedPaths, journaled(unsetCmd))
+	case "update":
+		argWrap(2, 3, NeedPaths, journaled(updateCmd))
+	case "install":
+		argWrap(2, 4, NeedGroups|NeedInstalled|NeedAliases, journaled(installCmd))
 	case "pull":
-		argWrap(4, 4, pullCmd)
+		argWrap(4, 4, NeedGroups|NeedAvailable|NeedInstalled|NeedAliases, journaled(pullCmd))
 	case "drop":
-		argWrap(4, 4, dropCmd)
-	case "edit":
-		argWrap(3, 3, editCmd)
+		argWrap(4, 5, NeedGroups|NeedInstalled|NeedAliases, journaled(dropCmd))
+	case "config":
+		argWrap(4, 7, NeedPaths, configCmd)
 	case "auth":
-		argWrap(3, 3, authCmd)
+		argWrap(3, 3, 0, authCmd)
 	case "push":
-		argWrap(3, 3, pushCmd)
-	default:
+		argWrap(4, 4, NeedAliases, journaled(pushCmd))
+	case "pack":
+		argWrap(3, 6, NeedPaths, packCmd)
+	case "export":
+		argWrap(4, 4, NeedGroups|NeedInstalled|NeedAvailable|NeedAliases, exportCmd)
+	case "import":
+		argWrap(3, 5, NeedGroups|NeedInstalled|NeedAvailable|NeedAliases, journaled(importCmd))
+	case "history":
+		argWrap(2, 2, NeedPaths, historyCmd)
+	case "undo":
+		argWrap(2, 2, NeedPaths, journaled(undoCmd))
+	case "lock":
+		argWrap(2, 3, NeedInstalled|NeedAliases, journaled(lockCmd))
+	case "usage", "help":
 		fmt.Println(Usage)
+	default:
+		externalCmd()
 	}
 }
 
-func argWrap(min, max int, fn func()) {
+// argWrap checks the number of arguments to a command, then loads what the
+// command needs before running it.
+func argWrap(min, max int, need Need, fn func()) {
 	n := len(os.Args)
 	if (min > 0 && n < min) || (max > 0 && n > max) {
 		fmt.Println(Usage)
-	} else {
-		fn()
+		return
+	}
+
+	if need != 0 && GroupPaths == nil {
+		SetupGroups()
+	}
+	if err := Require(need); err != nil {
+		warn(err.Error())
 	}
+	fn()
 }
 
 func initCmd() {
-	if LocalGroupPath == PWD {
+	if LocalDirPath == PWD {
 		fail("Group already exists")
 	}
-	path := filepath.Join(PWD, OSDir, StrVersions)
-	if err := os.MkdirAll(path, util.PermPublic); err != nil {
-		fail("")
+
+	group := filepath.Join(PWD, OSDir)
+	if err := mkdirAll(group); err != nil {
+		fail(err.Error())
+	}
+	if err := writeMap(filepath.Join(group, StrVersions), map[string]string{}); err != nil {
+		fail(err.Error())
+	}
+	if err := RegisterGroup(group); err != nil {
+		warn("Failed to register group: %s", err)
 	}
 }
 
 func whichCmd() {
 	var group, pack string
-	var ok bool
 
 	for i := 2; i < len(os.Args); i++ {
 		switch os.Args[i] {
@@ -383,18 +405,26 @@ func whichCmd() {
 		return
 	}
 
-	if group != StrGlobal {
-		if _, ok = localMap[pack]; ok {
-			fmt.Println(LocalGroupPath)
-			return
-		}
+	if path, ok := Which(pack, group); ok {
+		fmt.Println(path)
 	}
+}
 
-	if group != StrLocal {
-		if _, ok = globalMap[pack]; ok {
-			fmt.Println(GlobalGroupPath)
-			return
-		}
+// Which finds the group which sets the version of a pack: the local or
+// global group if one is named, or else the nearest group which sets it.
+func Which(pack, group string) (string, bool) {
+	var ok bool
+	switch group {
+	case StrLocal:
+		_, ok = localMap[pack]
+		return LocalGroupPath, ok
+	case StrGlobal:
+		_, ok = globalMap[pack]
+		return GlobalGroupPath, ok
+	default:
+		var source string
+		source, ok = sourceMap[pack]
+		return source, ok
 	}
 }
 
@@ -435,12 +465,15 @@ func removeCmd() {
 	if LocalGroupPath == GlobalGroupPath {
 		fail("Cannot remove global group")
 	}
-	if LocalGroupPath != PWD {
+	if LocalDirPath != PWD {
 		fail("Group does not exist")
 	}
-	if err := os.RemoveAll(filepath.Join(PWD, OSDir)); err != nil {
+	if err := removeAll(LocalGroupPath); err != nil {
 		fail(err.Error())
 	}
+	if err := UnregisterGroup(LocalGroupPath); err != nil {
+		warn("Failed to unregister group: %s", err)
+	}
 }
 
 func installedCmd() {
@@ -452,29 +485,44 @@ func installedCmd() {
 }
 
 func availableCmd() {
+	if len(os.Args) == 4 {
+		if os.Args[3] != "--remote" {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		availableRemoteCmd()
+		return
+	}
+
 	if versions, ok := availableMap[os.Args[2]]; ok {
 		for _, version := range versions {
 			fmt.Println(version)
 		}
 	}
-	if aliases, ok := aliasesMap[os.Args[2]]; ok {
-		for alias := range aliases {
-			fmt.Println(alias)
-		}
+	for _, alias := range sortedKeys(Aliases(os.Args[2])) {
+		fmt.Println(alias)
 	}
 }
 
 func stableCmd() {
-	fmt.Println(ResolveAlias(os.Args[2], "stable"))
+	printAlias(os.Args[2], StrStable)
 }
 
 func latestCmd() {
-	fmt.Println(ResolveAlias(os.Args[2], "latest"))
+	printAlias(os.Args[2], StrLatest)
+}
+
+// Print the version an alias resolves to, failing if it resolves to none.
+func printAlias(pack, alias string) {
+	concrete := ResolveAlias(pack, alias)
+	if concrete == alias {
+		fail("No %s version of %s", alias, pack)
+	}
+	fmt.Println(concrete)
 }
 
 func setCmd() {
 	pack := os.Args[2]
-	version := ResolveAlias(pack, os.Args[3])
 
 	base := LocalGroupPath
 	if len(os.Args) == 5 {
@@ -486,13 +534,22 @@ func setCmd() {
 		}
 	}
 
-	if _, ok := installedMap[pack]; !ok {
-		fail("Version %s of %s is not installed")
+	version, ok := ResolveInstalled(pack, os.Args[3])
+	if !ok {
+		fail("Version %s of %s is not installed", os.Args[3], pack)
 	}
-	currentMap[pack] = version
 
 	path := filepath.Join(base, StrVersions)
-	if err := util.WriteMap(path, currentMap); err != nil {
+	versions, err := util.ReadMap(path)
+	if err != nil && !os.IsNotExist(err) {
+		fail(err.Error())
+	}
+	if versions == nil {
+		versions = make(map[string]string)
+	}
+	versions[pack] = version
+
+	if err := writeMap(path, versions); err != nil {
 		fail("Failed to save version")
 	}
 }
@@ -510,62 +567,90 @@ func unsetCmd() {
 		}
 	}
 
-	if err := os.RemoveAll(filepath.Join(base, "versions", pack)); err != nil {
+	path := filepath.Join(base, StrVersions)
+	versions, err := util.ReadMap(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
 		fail(err.Error())
 	}
+	if _, ok := versions[pack]; !ok {
+		return
+	}
+	delete(versions, pack)
+
+	if err := writeMap(path, versions); err != nil {
+		fail("Failed to save versions")
+	}
 }
 
 func pullCmd() {
 	pack := os.Args[2]
-	version := ResolveAlias(pack, os.Args[3])
 
-	var bin string
+	// Pulling a pack updates its definition.
 	if pack == StrPack {
-		bin = filepath.Join(GlobalGroupPath, StrBin, "pull")
-	} else {
-		bin = filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version, StrBin, "pull")
+		if err := UpdatePack(os.Args[3]); err != nil {
+			fail(err.Error())
+		}
+		return
 	}
 
-	if err := util.Cmd(bin); err != nil {
+	if err := Pull(pack, ResolvePull(pack, os.Args[3]), os.Stdout); err != nil {
 		fail(err.Error())
 	}
+	refreshIndex()
 }
 
+// Drop a version, or a whole pack. Refuse to drop anything a known group or
+// alias still uses unless forced, and confirm before dropping a pack.
 func dropCmd() {
 	pack := os.Args[2]
-	version := ResolveAlias(pack, os.Args[3])
+	version := os.Args[3]
 
-	var path string
-	if pack == StrPack {
-		path = filepath.Join(GlobalGroupPath, StrPacks, version)
-	} else {
-		path = filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version)
+	force := false
+	if len(os.Args) == 5 {
+		if os.Args[4] != "--force" {
+			fmt.Println(Usage)
+			os.Exit(1)
+		}
+		force = true
 	}
 
-	if err := os.RemoveAll(path); err != nil {
+	if pack == StrPack {
+		dropPack(version, force)
+		return
+	}
+	if err := ValidPackName(pack); err != nil {
 		fail(err.Error())
 	}
-}
 
-func editCmd() {
-	pack := os.Args[2]
-	version := ResolveAlias(pack, os.Args[3])
-
-	var path string
-	if pack == StrPack {
-		path = filepath.Join(GlobalGroupPath, StrPacks, version)
-	} else {
-		path = filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version)
+	version, err := ResolveDrop(pack, version)
+	if err != nil {
+		fail(err.Error())
+	}
+	if !force {
+		refuseDependants(pack+" "+version, pack, version)
 	}
 
-	edit, ok := os.LookupEnv("EDITOR")
-	if !ok || edit == "" {
-		fail("Set EDITOR to edit config")
+	if err := removeAll(filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version)); err != nil {
+		fail(err.Error())
 	}
+	if err := removeAll(ReceiptPath(pack, version)); err != nil {
+		fail(err.Error())
+	}
+	refreshIndex()
+}
 
-	if err := util.Cmd(edit, path); err != nil {
+// Fail if anything references a version of a pack, or any version if none
+// is given, listing what does.
+func refuseDependants(name, pack, version string) {
+	dependants, err := Dependants(pack, version)
+	if err != nil {
 		fail(err.Error())
 	}
+	if len(dependants) > 0 {
+		fail("Can not drop %s, which is used by:\n  %s\nRun with --force to drop it anyway", name, strings.Join(dependants, "\n  "))
+	}
 }
 
 func authCmd() {
@@ -583,7 +668,7 @@ func pushCmd() {
 		bin = filepath.Join(GlobalGroupPath, StrPacks, pack, StrInstalled, version, StrBin, "pull")
 	}
 
-	if err := util.Cmd(bin); err != nil {
+	if err := run(os.Stdout, os.Stderr, nil, bin); err != nil {
 		fail(err.Error())
 	}
 }
@@ -1,17 +1,53 @@
 package main_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	xvm "github.com/skotchpine/xvm"
+	"github.com/skotchpine/xvm/util"
 )
 
 var (
 	root = filepath.Join(os.TempDir(), "xvm-main-test")
 )
 
+// Write files and their parent directories.
+func writeFiles(tb testing.TB, files map[string]string) {
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0777); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// Create a file which is found by globs but can not be read.
+func badFile(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path+"-missing", path); err != nil {
+		t.Skip("symlinks are not supported")
+	}
+}
+
 func TestGlobalGroup(t *testing.T) {
 	expectedDir := filepath.Join(root, "HOME")
 	expectedGroup := filepath.Join(expectedDir, "XVM")
@@ -99,17 +135,1454 @@ func TestMapBin(t *testing.T) {
 }
 
 func TestMapAvailable(t *testing.T) {
-	t.Skip()
+	dir := filepath.Join(root, "map-available")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "a", "available"):        "1.0\n",
+		filepath.Join(dir, "packs", "c", "available"):        "3.0\n",
+		filepath.Join(dir, "packs", "d", "available"):        "4.0\n",
+		filepath.Join(dir, "packs", "d", "available.remote"): "4.0 2017-01-01 prerelease\n4.1\n",
+	})
+	badFile(t, filepath.Join(dir, "packs", "b", "available"))
+
+	if err := xvm.MapAvailable(context.Background()); err == nil {
+		t.Error("Expected an error for the unreadable available versions of b")
+	}
+	available := xvm.AvailableMap()
+	for pack, version := range map[string]string{"a": "1.0", "c": "3.0"} {
+		if versions := available[pack]; len(versions) != 1 || versions[0] != version {
+			t.Errorf("Expected %s to have available version %s, got %v", pack, version, versions)
+		}
+	}
+
+	// Fetched versions are merged over those the definition ships.
+	if versions := available["d"]; strings.Join(versions, " ") != "4.0 4.1" {
+		t.Errorf("Expected d to have available versions 4.0 and 4.1, got %v", versions)
+	}
+	if !xvm.PreMap()["d"]["4.0"] {
+		t.Error("Expected the fetched prerelease flag of 4.0 to win")
+	}
 }
 
 func TestMapAliases(t *testing.T) {
-	t.Skip()
+	dir := filepath.Join(root, "map-aliases")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "a", "aliases"): "stable 1.0\n",
+		filepath.Join(dir, "packs", "c", "aliases"): "stable 3.0\n",
+	})
+	badFile(t, filepath.Join(dir, "packs", "b", "aliases"))
+
+	if err := xvm.MapAliases(context.Background()); err == nil {
+		t.Error("Expected an error for the unreadable aliases of b")
+	}
+	aliases := xvm.AliasesMap()
+	for pack, version := range map[string]string{"a": "1.0", "c": "3.0"} {
+		if actual := aliases[pack]["stable"]; actual != version {
+			t.Errorf("Expected stable %s to be %s, got %s", pack, version, actual)
+		}
+	}
+}
+
+func TestComputeAlias(t *testing.T) {
+	dir := filepath.Join(root, "compute-alias")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "available"):   "go1.9.2\ngo1.10rc1\ngo1.10beta2\ngo1.8.5\n",
+		filepath.Join(dir, "packs", "node", "available"): "8.9.0 2017-10-31\n9.0.0 2017-10-31 prerelease\n",
+		filepath.Join(dir, "packs", "ruby", "aliases"):   "stable 2.3.0\n",
+		filepath.Join(dir, "packs", "ruby", "available"): "2.3.0\n2.4.2\n",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	if versions := xvm.AvailableMap()["go"]; strings.Join(versions, " ") != "go1.8.5 go1.9.2 go1.10beta2 go1.10rc1" {
+		t.Errorf("Expected available versions of go in version order, got %v", versions)
+	}
+
+	tests := []struct{ pack, alias, expected string }{
+		{"go", "latest", "go1.10rc1"},
+		{"go", "stable", "go1.9.2"},
+		{"node", "latest", "9.0.0"},
+		{"node", "stable", "8.9.0"},
+		{"ruby", "stable", "2.3.0"},
+		{"ruby", "latest", "2.4.2"},
+		{"python", "latest", "latest"},
+	}
+	for _, test := range tests {
+		if actual := xvm.ResolveAlias(test.pack, test.alias); actual != test.expected {
+			t.Errorf("Expected %s %s to be %s, got %s", test.pack, test.alias, test.expected, actual)
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	dir := filepath.Join(root, "alias")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "available"):                     "1.8\n1.9\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.9", "bin", "go"): "",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alias := range [][2]string{{"1.9", "prod"}, {"prod", "ci"}, {"1.8", "old"}} {
+		if err := xvm.AddAlias("", "go", alias[0], alias[1]); err != nil {
+			t.Errorf("Expected to alias %s as %s: %s", alias[0], alias[1], err)
+		}
+	}
+	for _, alias := range [][2]string{{"1.7", "older"}, {"ci", "prod"}, {"1.8", "1.9"}, {"1.9", "x"}} {
+		pack := "go"
+		if alias[1] == "x" {
+			pack = "node"
+		}
+		if err := xvm.AddAlias("", pack, alias[0], alias[1]); err == nil {
+			t.Errorf("Expected not to alias %s %s as %s", pack, alias[0], alias[1])
+		}
+	}
+
+	if err := xvm.Load(context.Background(), xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+	if actual := xvm.ResolveAlias("go", "ci"); actual != "1.9" {
+		t.Errorf("Expected ci to resolve through prod to 1.9, got %s", actual)
+	}
+
+	if err := xvm.RemoveAlias("", "go", "prod"); err == nil {
+		t.Error("Expected not to remove prod while ci points at it")
+	}
+	for _, alias := range []string{"ci", "prod"} {
+		if err := xvm.RemoveAlias("", "go", alias); err != nil {
+			t.Errorf("Expected to remove %s: %s", alias, err)
+		}
+	}
+	if err := xvm.RemoveAlias("", "go", "prod"); err == nil {
+		t.Error("Expected not to remove a missing alias")
+	}
+
+	if _, err := xvm.FollowAlias(map[string]string{"a": "b", "b": "a"}, "a"); err == nil {
+		t.Error("Expected an alias cycle to fail")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir := filepath.Join(root, "journal")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "available"): "1.8\n1.9\n",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := func(fn func() error) {
+		xvm.StartEntry([]string{"test"})
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+		if err := xvm.FinishEntry(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	undo := func() error {
+		_, err := xvm.Undo()
+		return err
+	}
+	alias := func() string {
+		aliases, _ := ioutil.ReadFile(xvm.AliasPath("", "go"))
+		return string(aliases)
+	}
+
+	journal(func() error { return xvm.AddAlias("", "go", "1.8", "prod") })
+	journal(func() error { return xvm.AddAlias("", "go", "1.9", "prod") })
+	journal(undo)
+	if actual := alias(); actual != "prod 1.8\n" {
+		t.Errorf("Expected undo to restore prod 1.8, got %q", actual)
+	}
+	journal(undo)
+	if actual := alias(); actual != "" {
+		t.Errorf("Expected a second undo to remove prod, got %q", actual)
+	}
+	if _, err := xvm.Undo(); err == nil {
+		t.Error("Expected nothing left to undo")
+	}
+
+	entries, err := xvm.ReadJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[2].Undoes != 2 || entries[3].Undoes != 1 {
+		t.Errorf("Expected two entries undone in reverse, got %+v", entries)
+	}
+
+	journal(func() error { return xvm.AddAlias("", "go", "1.8", "ci") })
+	writeFiles(t, map[string]string{xvm.AliasPath("", "go"): "ci 1.9\n"})
+	if _, err := xvm.Undo(); err == nil {
+		t.Error("Expected not to undo a change made since")
+	}
+}
+
+func TestGroupAliases(t *testing.T) {
+	dir := filepath.Join(root, "group-aliases")
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "project", ".xvm")
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{local, dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "available"): "1.7\n1.8\n1.9\n",
+		filepath.Join(dir, "packs", "go", "aliases"):   "ci 1.7\nold 1.7\n",
+		filepath.Join(dir, "aliases"):                  "go@ci 1.9\ngo@prod 1.9\n",
+		filepath.Join(local, "aliases"):                "go@ci 1.8\nnode@ci 8.9.0\n",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	for alias, expected := range map[string]string{"ci": "1.8", "prod": "1.9", "old": "1.7"} {
+		if actual := xvm.ResolveAlias("go", alias); actual != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", alias, expected, actual)
+		}
+	}
+
+	if err := xvm.AddAlias(local, "go", "prod", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := xvm.ReadGroupAliases(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliases["go"]["staging"] != "prod" || aliases["go"]["ci"] != "1.8" || aliases["node"]["ci"] != "8.9.0" {
+		t.Errorf("Expected go@staging to be added to the local aliases, got %v", aliases)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	versions := map[string]string{"go": "1.9.4", "node": "8.9.0", "python": "3.6.3"}
+
+	expected := map[string]string{
+		xvm.FormatToolVersions: "golang 1.9.4\nnodejs 8.9.0\npython 3.6.3\n",
+		xvm.FormatNvmrc:        "8.9.0\n",
+		xvm.FormatGoVersion:    "1.9.4\n",
+		xvm.FormatJSON:         "{\n  \"go\": \"1.9.4\",\n  \"node\": \"8.9.0\",\n  \"python\": \"3.6.3\"\n}\n",
+	}
+	for _, format := range xvm.Formats {
+		data, err := xvm.Export(versions, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected[format] {
+			t.Errorf("Expected %s export %q, got %q", format, expected[format], data)
+		}
+
+		imported, err := xvm.Import(data, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for pack, version := range imported {
+			if versions[pack] != version {
+				t.Errorf("Expected %s import of %s to be %s, got %s", format, pack, versions[pack], version)
+			}
+		}
+	}
+
+	imported, err := xvm.Import([]byte("# tools\nnodejs 8.9.0 7.10.0 # fallback\n\nruby system\n"), xvm.FormatToolVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 || imported["node"] != "8.9.0" || imported["ruby"] != "system" {
+		t.Errorf("Expected node 8.9.0 and ruby system, got %v", imported)
+	}
+
+	for _, data := range []string{`{"go": ""}`, `{"go": "1.9 1.8"}`, `{"../go": "1.9"}`} {
+		if _, err := xvm.Import([]byte(data), xvm.FormatJSON); err == nil {
+			t.Errorf("Expected importing %s to fail", data)
+		}
+	}
+
+	if _, err := xvm.Export(map[string]string{"go": "1.9.4"}, xvm.FormatNvmrc); err == nil {
+		t.Error("Expected exporting nvmrc without node to fail")
+	}
+	if format, err := xvm.DetectFormat(filepath.Join("project", ".tool-versions")); err != nil || format != xvm.FormatToolVersions {
+		t.Errorf("Expected .tool-versions to be detected, got %s: %v", format, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := filepath.Join(root, "load")
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "versions"):                                   "a 1.0\n",
+		filepath.Join(dir, "packs", "a", "available"):                    "1.0\n",
+		filepath.Join(dir, "packs", "a", "aliases"):                      "stable 1.0\n",
+		filepath.Join(dir, "packs", "a", "installed", "1.0", "bin", "a"): "",
+	})
+	badFile(t, filepath.Join(dir, "packs", "b", "aliases"))
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+
+	err := xvm.Load(context.Background(), xvm.NeedAll)
+	if errs, ok := err.(xvm.LoadError); !ok || len(errs) != 1 {
+		t.Errorf("Expected one load error, got %v", err)
+	}
+
+	// Every map must be complete, and safe to read concurrently, once Load returns.
+	done := make(chan bool)
+	checks := []func() bool{
+		func() bool { return xvm.CurrentMap()["a"] == "1.0" },
+		func() bool { return len(xvm.AvailableMap()["a"]) == 1 },
+		func() bool { return len(xvm.InstalledMap()["a"]) == 1 },
+		func() bool { return xvm.BinMap()["a"] == "a" },
+		func() bool { return xvm.AliasesMap()["a"]["stable"] == "1.0" },
+	}
+	for i, check := range checks {
+		go func(i int, check func() bool) {
+			if !check() {
+				t.Errorf("Map %d was not loaded", i)
+			}
+			done <- true
+		}(i, check)
+	}
+	for range checks {
+		<-done
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := xvm.Load(ctx, xvm.NeedAll); err != context.Canceled {
+		t.Errorf("Expected a canceled load, got %v", err)
+	}
 }
 
 func TestResolveAlias(t *testing.T) {
-	t.Skip()
+	dir := filepath.Join(root, "resolve-alias")
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "project", ".xvm")
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{local, dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "available"):                       "1.7\n1.8\n1.9.2\n1.9.4\n",
+		filepath.Join(dir, "packs", "go", "aliases"):                         "ci stable\nstable 1.8\nold 1.7\nloop cycle\ncycle loop\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.8", "bin", "go"):   "",
+		filepath.Join(dir, "packs", "go", "installed", "1.9.2", "bin", "go"): "",
+		filepath.Join(dir, "packs", "go", "installed", "1.9.4", "bin", "go"): "",
+		filepath.Join(dir, "aliases"):                                        "go@prod ci\n",
+		filepath.Join(local, "aliases"):                                      "go@ci 1.9\n",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	// Group aliases come before those of the pack, and chains are followed
+	// through both. Latest is computed unless it is aliased.
+	for alias, expected := range map[string]string{
+		"ci":     "1.9",
+		"prod":   "1.9",
+		"stable": "1.8",
+		"old":    "1.7",
+		"latest": "1.9.4",
+		"1.9.2":  "1.9.2",
+		"loop":   "loop",
+	} {
+		if actual := xvm.ResolveAlias("go", alias); actual != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", alias, expected, actual)
+		}
+	}
+
+	// A resolved alias may be a partial version.
+	for alias, expected := range map[string]string{"prod": "1.9.4", "stable": "1.8"} {
+		if actual, ok := xvm.ResolveInstalled("go", alias); !ok || actual != expected {
+			t.Errorf("Expected %s to match %s, got %s", alias, expected, actual)
+		}
+	}
+	if actual, ok := xvm.ResolveInstalled("go", "old"); ok {
+		t.Errorf("Expected old to match nothing installed, got %s", actual)
+	}
 }
 
 func TestWrapBin(t *testing.T) {
-	t.Skip()
+	if os.Getenv("XVM_TEST_WRAP_BIN") != "" {
+		os.Args = []string{"go", "version", "-v"}
+		xvm.SetupGroups()
+		if err := xvm.Load(context.Background(), xvm.NeedGroups); err != nil {
+			t.Fatal(err)
+		}
+		xvm.WrapBin("go")
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("executables need an extension")
+	}
+
+	dir := filepath.Join(root, "wrap-bin")
+	defer os.RemoveAll(dir)
+
+	// The local group sets an alias, which the group alias and then a
+	// partial match resolve to 1.9.4.
+	project := filepath.Join(dir, "project")
+	script := "#!/bin/sh\necho %s \"$@\"\nexit 3\n"
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "aliases"):                         "ci 1.8\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.8", "bin", "go"):   fmt.Sprintf(script, "1.8"),
+		filepath.Join(dir, "packs", "go", "installed", "1.9.2", "bin", "go"): fmt.Sprintf(script, "1.9.2"),
+		filepath.Join(dir, "packs", "go", "installed", "1.9.4", "bin", "go"): fmt.Sprintf(script, "1.9.4"),
+		filepath.Join(dir, "versions"):                                       "go 1.8\n",
+		filepath.Join(project, ".xvm", "versions"):                           "go ci\n",
+		filepath.Join(project, ".xvm", "aliases"):                            "go@ci 1.9\n",
+	})
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWrapBin$")
+	cmd.Dir = project
+	cmd.Env = append(os.Environ(), "XVM_TEST_WRAP_BIN=1", "XVMPATH="+dir)
+	out, err := cmd.Output()
+	exit, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("Expected the executable's exit status, got %v", err)
+	}
+	if exit.ExitCode() != 3 {
+		t.Errorf("Expected exit status 3, got %d", exit.ExitCode())
+	}
+	if expected := "1.9.4 version -v\n"; string(out) != expected {
+		t.Errorf("Expected output %q, got %q", expected, out)
+	}
+}
+
+func TestAddPack(t *testing.T) {
+	dir := filepath.Join(root, "add-pack")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = filepath.Join(dir, "xvm")
+	def := filepath.Join(dir, "def")
+	writeFiles(t, map[string]string{
+		filepath.Join(def, "bin", "pull"): "#!/bin/sh\n",
+		filepath.Join(def, "info"):        "version 1.0\n",
+		filepath.Join(def, "available"):   "1.0\n",
+	})
+
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "../test"} {
+		if err := xvm.AddPack(name, def, ""); err == nil {
+			t.Errorf("Expected pack name %q to be rejected", name)
+		}
+		if err := xvm.Pull(name, "1.0", ioutil.Discard); err == nil {
+			t.Errorf("Expected pulling pack %q to be rejected", name)
+		}
+	}
+	if err := xvm.Pull("test", "..", ioutil.Discard); err == nil {
+		t.Error("Expected pulling version .. to be rejected")
+	}
+	if _, err := os.Stat(xvm.GlobalGroupPath); err == nil {
+		t.Error("Expected rejected packs to leave the global group alone")
+	}
+
+	if err := xvm.AddPack("test", def, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(xvm.PackPath("test", "pack", "bin", "pull")); err != nil {
+		t.Error("Pack definition was not installed")
+	}
+	if _, err := os.Stat(xvm.PackPath("test", "available")); err != nil {
+		t.Error("Available versions were not copied from the definition")
+	}
+
+	packs, err := xvm.ListPacks()
+	if err != nil {
+		t.Error(err)
+	}
+	if version := packs["test"]; version != "1.0" {
+		t.Errorf("Expected version 1.0, got %s", version)
+	}
+
+	if err := os.Remove(filepath.Join(def, "bin", "pull")); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.UpdatePack("test"); err == nil {
+		t.Error("Expected an error updating to a definition without bin/pull")
+	}
+	if _, err := os.Stat(xvm.PackPath("test", "pack", "bin", "pull")); err != nil {
+		t.Error("A failed update replaced the previous definition")
+	}
+}
+
+func TestAddPackGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := filepath.Join(root, "add-pack-git")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = filepath.Join(dir, "xvm")
+	repo := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "bin"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "bin", "pull"), []byte("#!/bin/sh\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	git := func(arg ...string) {
+		c := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=xvm", "-c", "user.email=xvm@localhost"}, arg...)...)
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", arg, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "pack")
+	git("tag", "v1")
+
+	if err := xvm.AddPack("test", repo, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(xvm.PackPath("test", "pack", "bin", "pull")); err != nil {
+		t.Error("Pack definition was not installed from git")
+	}
+
+	packs, err := xvm.ListPacks()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(packs["test"]) != 40 {
+		t.Errorf("Expected a commit version, got %s", packs["test"])
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	dir := filepath.Join(root, "diagnose")
+	defer os.RemoveAll(dir)
+
+	group := filepath.Join(dir, "xvm")
+	bin := filepath.Join(group, "packs", "test", "installed", "1.0", "bin")
+	broken := filepath.Join(group, "packs", "broken", "aliases")
+	if err := os.MkdirAll(broken, 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{
+		filepath.Join(group, "versions"):                 "test 1.0\ntest 2.0\n",
+		filepath.Join(group, "packs", "test", "aliases"): "stable 3.0\n",
+		filepath.Join(group, "aliases"):                  "ghost@ci 1.0\ntest@ci 4.0\ntest@up down\ntest@down up\ntest@ok 1.0\n",
+		filepath.Join(bin, "test"):                       "",
+	})
+	if err := os.Chmod(filepath.Join(bin, "test"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XVMPATH", group)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(group, "bin"))
+	xvm.Setup()
+
+	problems := xvm.Diagnose()
+	expected := []string{
+		group + " pins test 2.0, which is not installed",
+		"Alias stable of test points at 3.0, which is neither installed nor available",
+		filepath.Join(bin, "test") + " is not executable",
+		group + " has aliases of ghost, which is not a pack",
+		"Alias ci of test points at 4.0, which is neither installed nor available",
+		"Alias cycle down -> up -> down",
+		"Alias cycle up -> down -> up",
+	}
+	if len(problems) != len(expected)+2 {
+		t.Fatalf("Expected %d problems, got %v", len(expected)+2, problems)
+	}
+	if !strings.HasPrefix(problems[0].Message, "Can not find aliases for broken: ") || problems[0].Severity != xvm.SevError {
+		t.Errorf("Expected the unreadable aliases of broken to be diagnosed first, got %v", problems[0])
+	}
+	duplicate := filepath.Join(group, "versions") + ":2:1: duplicate key test, first set on line 1"
+	if problems[1].Message != duplicate || problems[1].Severity != xvm.SevWarning {
+		t.Errorf("Expected a warning that '%s', got %v", duplicate, problems)
+	}
+	for _, message := range expected {
+		missing := true
+		for _, problem := range problems {
+			if problem.Message == message && problem.Severity == xvm.SevError {
+				missing = false
+			}
+		}
+		if missing {
+			t.Errorf("Did not diagnose '%s'", message)
+		}
+	}
+}
+
+func TestUnreferenced(t *testing.T) {
+	dir := filepath.Join(root, "unreferenced")
+	defer os.RemoveAll(dir)
+
+	global := filepath.Join(dir, "xvm")
+	local := filepath.Join(dir, "project", xvm.OSDir)
+	files := map[string]string{
+		filepath.Join(global, "versions"):                 "test 1.0\n",
+		filepath.Join(global, "packs", "test", "aliases"): "stable 5.0\n",
+		filepath.Join(local, "versions"):                  "test 2.0\n",
+	}
+	for _, version := range []string{"1.0", "2.0", "3.0", "4.0", "5.0"} {
+		files[filepath.Join(global, "packs", "test", "installed", version, "bin", "test")] = ""
+	}
+	writeFiles(t, files)
+
+	// Using the local group registers it, as commands do.
+	if err := os.Chdir(filepath.Dir(local)); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XVMPATH", global)
+	xvm.Setup()
+	xvm.RegisterGroups()
+
+	// Prune from elsewhere still knows the local group.
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	xvm.Setup()
+	if len(xvm.GroupPaths) != 1 {
+		t.Fatalf("Expected only the global group to apply, got %v", xvm.GroupPaths)
+	}
+
+	unreferenced, err := xvm.Unreferenced()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := unreferenced["test"]; len(versions) != 2 || versions[0] != "3.0" || versions[1] != "4.0" {
+		t.Errorf("Expected only 3.0 and 4.0 to be unreferenced, got %v", versions)
+	}
+}
+
+func TestDependants(t *testing.T) {
+	dir := filepath.Join(root, "dependants")
+	defer os.RemoveAll(dir)
+
+	global := filepath.Join(dir, "xvm")
+	local := filepath.Join(dir, "project", xvm.OSDir)
+	files := map[string]string{
+		filepath.Join(global, "versions"):                 "test 1\n",
+		filepath.Join(global, "packs", "test", "aliases"): "stable 2.0\n",
+		filepath.Join(local, "versions"):                  "test stable\n",
+	}
+	for _, version := range []string{"1.0", "2.0", "3.0"} {
+		files[filepath.Join(global, "packs", "test", "installed", version, "bin", "test")] = ""
+	}
+	writeFiles(t, files)
+
+	if err := os.Chdir(filepath.Dir(local)); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XVMPATH", global)
+	xvm.Setup()
+
+	cases := map[string][]string{
+		"1.0": {global + " pins test 1"},
+		"2.0": {local + " pins test stable", "alias stable of test"},
+		"3.0": nil,
+		"":    {local + " pins test stable", global + " pins test 1", "alias stable of test"},
+	}
+	for version, expected := range cases {
+		dependants, err := xvm.Dependants("test", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(dependants, "; ") != strings.Join(expected, "; ") {
+			t.Errorf("Expected dependants of %q to be %v, got %v", version, expected, dependants)
+		}
+	}
+}
+
+func TestFindGroups(t *testing.T) {
+	dir := filepath.Join(root, "find-groups")
+	defer os.RemoveAll(dir)
+
+	global := filepath.Join(dir, "home", "xvm")
+	repo := filepath.Join(dir, "repo", xvm.OSDir)
+	legacy := filepath.Join(dir, "repo", "services", "legacy", xvm.OSDir)
+	pwd := filepath.Join(dir, "repo", "services", "legacy", "cmd")
+	for _, path := range []string{global, repo, legacy, pwd} {
+		if err := os.MkdirAll(path, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Chdir(pwd); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XVMPATH", global)
+	xvm.GlobalGroupPath, xvm.GlobalDirPath = xvm.FindGlobalGroup()
+
+	expected := []string{legacy, repo, global}
+	actual := xvm.FindGroups()
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %s at layer %d, got %s", expected[i], i, actual[i])
+		}
+	}
+
+	// The nearest group which pins a pack supplies its version.
+	writeFiles(t, map[string]string{
+		filepath.Join(global, "versions"): "a 1\nb 1\n",
+		filepath.Join(repo, "versions"):   "b 2\nc 2\n",
+		filepath.Join(legacy, "versions"): "c 3\n",
+	})
+	xvm.SetupGroups()
+	if err := xvm.MapGroups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for pack, group := range map[string]string{"a": global, "b": repo, "c": legacy} {
+		if source := xvm.SourceMap()[pack]; source != group {
+			t.Errorf("Expected %s to be set by %s, got %s", pack, group, source)
+		}
+	}
+	if current := xvm.CurrentMap(); current["a"] != "1" || current["b"] != "2" || current["c"] != "3" {
+		t.Errorf("Expected the nearest versions to be current, got %v", current)
+	}
+
+	which := []struct {
+		pack, group, path string
+		ok                bool
+	}{
+		{"a", "", global, true},
+		{"b", "", repo, true},
+		{"c", "", legacy, true},
+		{"d", "", "", false},
+		{"c", "local", legacy, true},
+		{"b", "local", legacy, false},
+		{"b", "global", global, true},
+		{"c", "global", global, false},
+	}
+	for _, w := range which {
+		path, ok := xvm.Which(w.pack, w.group)
+		if ok != w.ok || (ok && path != w.path) {
+			t.Errorf("Expected which %s %s to be %s %v, got %s %v", w.pack, w.group, w.path, w.ok, path, ok)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := filepath.Join(root, "lock")
+	defer os.RemoveAll(dir)
+
+	global := filepath.Join(dir, "xvm")
+	writeFiles(t, map[string]string{
+		filepath.Join(global, "versions"):                                 "test stable\n",
+		filepath.Join(global, "packs", "test", "aliases"):                 "stable 1.0\n",
+		filepath.Join(global, "packs", "test", "receipts", "1.0"):         "source https://localhost/1.0.tgz\nchecksum sha256:10\n",
+		filepath.Join(global, "packs", "test", "installed", "1.0", "bin"): "",
+		filepath.Join(global, "packs", "test", "installed", "2.0", "bin"): "",
+	})
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XVMPATH", global)
+	xvm.Setup()
+
+	if stale, err := xvm.StaleLock(global); err != nil || len(stale) != 1 {
+		t.Errorf("Expected a missing lockfile to be stale, got %v %v", stale, err)
+	}
+
+	locks, err := xvm.ResolveLock(global)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := xvm.Lock{"stable", "1.0", "sha256:10", "https://localhost/1.0.tgz"}
+	if locks["test"] != expected {
+		t.Errorf("Expected %v, got %v", expected, locks["test"])
+	}
+	if err := xvm.WriteLock(global, locks); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := xvm.StaleLock(global); err != nil || len(stale) != 0 {
+		t.Errorf("Expected a fresh lockfile, got %v %v", stale, err)
+	}
+
+	// Moving the alias must not move the lock.
+	alias := filepath.Join(global, "packs", "test", "aliases")
+	if err := ioutil.WriteFile(alias, []byte("stable 2.0\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	xvm.Setup()
+	if locks, err = xvm.ResolveLock(global); err != nil || locks["test"].Version != "1.0" {
+		t.Errorf("Expected the lock to keep 1.0, got %v %v", locks["test"], err)
+	}
+
+	versions := filepath.Join(global, "versions")
+	if err := ioutil.WriteFile(versions, []byte("test 2.0\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := xvm.StaleLock(global); err != nil || len(stale) != 1 {
+		t.Errorf("Expected a changed version to be stale, got %v %v", stale, err)
+	}
+
+	// The locked version is still used until the lockfile is rewritten.
+	if dependants, err := xvm.Dependants("test", "1.0"); err != nil || len(dependants) != 1 {
+		t.Errorf("Expected the lockfile to depend on 1.0, got %v %v", dependants, err)
+	}
+
+	if err := ioutil.WriteFile(xvm.LockPath(global), []byte("test 1.0\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.MapGroups(context.Background()); err == nil {
+		t.Error("Expected a malformed lockfile to fail to load")
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	dir := filepath.Join(root, "receipt")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	installed := xvm.PackPath("test", "installed", "1.0", "bin", "test")
+	writeFiles(t, map[string]string{
+		installed:                      "",
+		xvm.ReceiptPath("test", "1.0"): "source https://localhost/1.0.tgz\n",
+	})
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(installed, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	_, before, err := xvm.Receipt("test", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{xvm.PackPath("test", "installed", "1.0", "cache"): "x"})
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(xvm.PackPath("test", "installed", "1.0", "cache"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, after, err := xvm.Receipt("test", "1.0"); err != nil || after != before {
+		t.Errorf("Expected a cache written after install not to change the checksum %s, got %s %v", before, after, err)
+	}
+}
+
+func TestPullAll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pull executables are shell scripts")
+	}
+
+	dir := filepath.Join(root, "pull-all")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = filepath.Join(dir, "xvm")
+	xvm.GroupPaths = []string{xvm.GlobalGroupPath}
+	pulls := map[string]string{
+		"good": "#!/bin/sh\necho one\necho two\nmkdir -p \"$XVM_PULL_PATH/bin\"\n",
+		"bad":  "#!/bin/sh\necho broken >&2\nexit 1\n",
+	}
+	for pack, script := range pulls {
+		bin := xvm.PackPath(pack, "pack", "bin")
+		if err := os.MkdirAll(bin, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(bin, "pull"), []byte(script), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	failed := xvm.PullAll(map[string]string{"good": "1.0", "bad": "2.0"}, 2, out)
+	if len(failed) != 1 || failed["bad"] == nil {
+		t.Errorf("Expected only bad to fail, got %v", failed)
+	}
+	if _, err := os.Stat(xvm.PackPath("good", "installed", "1.0", "bin")); err != nil {
+		t.Error("Expected good 1.0 to be installed")
+	}
+	if _, err := os.Stat(xvm.PackPath("bad", "installed", "2.0")); err == nil {
+		t.Error("Expected the failed installation of bad 2.0 to be removed")
+	}
+
+	for _, line := range []string{"good 1.0: one\n", "good 1.0: two\n", "good 1.0: done\n", "bad 2.0: broken\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected output to contain %q, got %q", line, out.String())
+		}
+	}
+}
+
+func TestAutoPull(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pull executables are shell scripts")
+	}
+
+	dir := filepath.Join(root, "auto-pull")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	count := filepath.Join(dir, "pulls")
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "versions"):                                     "go 1.9\n",
+		filepath.Join(dir, "packs", "go", "available"):                     "1.8\n1.9.2\n1.9.4\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.8", "bin", "go"): "",
+	})
+	script := "#!/bin/sh\nsleep 0.2\necho pull >> " + count + "\nmkdir -p \"$XVM_PULL_PATH/bin\"\ntouch \"$XVM_PULL_PATH/bin/go\"\n"
+	bin := xvm.PackPath("go", "pack", "bin")
+	if err := os.MkdirAll(bin, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "pull"), []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := xvm.ResolveBin("go")
+	if missing, ok := err.(*xvm.MissingError); !ok || missing.Version != "1.9" {
+		t.Fatalf("Expected go 1.9 to be missing, got %v", err)
+	}
+
+	// Concurrent shims pull the version once.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := xvm.AutoPull("go", "1.9"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if pulls, _ := ioutil.ReadFile(count); string(pulls) != "pull\n" {
+		t.Errorf("Expected one pull, got %q", pulls)
+	}
+	path, err := xvm.ResolveBin("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := xvm.PackPath("go", "installed", "1.9.4", "bin", "go"); path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+
+	// Pulls by pull and install wait on the same lock as shims.
+	unlock, err := util.Lock(xvm.PackPath("go", ".pull.lock"), xvm.StalePullLock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		err := xvm.Pull("go", "1.9.2", w)
+		w.Close()
+		done <- err
+	}()
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	if line != "Waiting for lock on go; another xvm is pulling it\n" {
+		t.Errorf("Expected the pull to wait for the lock, got %q", line)
+	}
+	if pulls, _ := ioutil.ReadFile(count); string(pulls) != "pull\n" {
+		t.Errorf("Expected no pull while the lock is held, got %q", pulls)
+	}
+	unlock()
+	go io.Copy(ioutil.Discard, r)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if pulls, _ := ioutil.ReadFile(count); string(pulls) != "pull\npull\n" {
+		t.Errorf("Expected the pull to run once the lock was released, got %q", pulls)
+	}
+}
+
+func TestAutoInstall(t *testing.T) {
+	dir := filepath.Join(root, "auto-install")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	os.Unsetenv("XVM_AUTO_INSTALL")
+	if xvm.AutoInstall() {
+		t.Error("Expected auto install to be off by default")
+	}
+
+	writeFiles(t, map[string]string{filepath.Join(dir, "config"): "auto_install true\n"})
+	if !xvm.AutoInstall() {
+		t.Error("Expected the global config to turn on auto install")
+	}
+
+	os.Setenv("XVM_AUTO_INSTALL", "0")
+	defer os.Unsetenv("XVM_AUTO_INSTALL")
+	if xvm.AutoInstall() {
+		t.Error("Expected XVM_AUTO_INSTALL to override the global config")
+	}
+}
+
+func TestPullFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pull executables are shell scripts")
+	}
+
+	dir := filepath.Join(root, "pull-failure")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "pack", "bin", "pull"):           "#!/bin/sh\nmkdir -p \"$XVM_PULL_PATH/bin\"\necho partial > \"$XVM_PULL_RECEIPT\"\nexit 1\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.8", "bin", "go"): "",
+		filepath.Join(dir, "packs", "go", "receipts", "1.8"):               "source https://example.com/go1.8.tgz\n",
+	})
+
+	for _, version := range []string{"1.8", "1.9"} {
+		if err := xvm.Pull("go", version, ioutil.Discard); err == nil {
+			t.Errorf("Expected pulling %s to fail", version)
+		}
+	}
+	if _, err := os.Stat(xvm.PackPath("go", "installed", "1.8", "bin", "go")); err != nil {
+		t.Error("Expected a failed pull to keep the installed version")
+	}
+	if receipt, _ := ioutil.ReadFile(xvm.ReceiptPath("go", "1.8")); string(receipt) != "source https://example.com/go1.8.tgz\n" {
+		t.Errorf("Expected a failed pull to keep the receipt, got %q", receipt)
+	}
+	for _, path := range []string{xvm.PackPath("go", "installed", "1.9"), xvm.ReceiptPath("go", "1.9")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected a failed pull to remove %s", path)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pull executables are shell scripts")
+	}
+
+	dir := filepath.Join(root, "config")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	seen := filepath.Join(dir, "seen")
+	script := "#!/bin/sh\nprintf '%s' \"$XVM_PULL_CONFIG\" > " + seen + "\nmkdir -p \"$XVM_PULL_PATH/bin\"\n"
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "packs", "go", "pack", "config"):      "arch Target architecture\ncgo Build with cgo\n",
+		filepath.Join(dir, "packs", "go", "pack", "bin", "pull"): script,
+	})
+
+	if err := xvm.SetConfig("go", "", "arch", "amd64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.SetConfig("go", "1.9", "arch", "arm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.SetConfig("go", "", "os", "linux"); err == nil {
+		t.Error("Expected an undeclared key to be rejected")
+	}
+	if err := xvm.SetConfig("node", "", "arch", "amd64"); err == nil {
+		t.Error("Expected config for a missing pack to be rejected")
+	}
+
+	if err := xvm.Pull("go", "1.9", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if actual, _ := ioutil.ReadFile(seen); string(actual) != "arch arm\n" {
+		t.Errorf("Expected go 1.9 to be pulled with arch arm, got %q", actual)
+	}
+
+	if err := xvm.UnsetConfig("go", "1.9", "arch"); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := xvm.MergedConfig("go", "1.9"); config["arch"] != "amd64" {
+		t.Errorf("Expected go 1.9 to fall back to arch amd64, got %v", config)
+	}
+	if err := xvm.UnsetConfig("go", "1.9", "arch"); err == nil {
+		t.Error("Expected unsetting a missing key to fail")
+	}
+}
+
+// Build a global group with many packs, versions and executables, where pack
+// p0 pins version v0 and provides the executable p0b0.
+func benchGroup(tb testing.TB, dir string, packs, versions, bins int) {
+	files := map[string]string{filepath.Join(dir, "versions"): "p0 stable\n"}
+	for p := 0; p < packs; p++ {
+		pack := fmt.Sprintf("p%d", p)
+		available := ""
+		for v := 0; v < versions; v++ {
+			version := fmt.Sprintf("v%d", v)
+			available += version + "\n"
+			for b := 0; b < bins; b++ {
+				files[filepath.Join(dir, "packs", pack, "installed", version, "bin", fmt.Sprintf("%sb%d", pack, b))] = ""
+			}
+		}
+		files[filepath.Join(dir, "packs", pack, "available")] = available
+		files[filepath.Join(dir, "packs", pack, "aliases")] = "stable v0\n"
+	}
+	writeFiles(tb, files)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+}
+
+func TestDryRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pull executables are shell scripts")
+	}
+
+	dir := filepath.Join(root, "dry-run")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = filepath.Join(dir, "xvm")
+	xvm.GroupPaths = []string{xvm.GlobalGroupPath}
+	pulled := filepath.Join(dir, "pulled")
+	def := filepath.Join(dir, "def")
+	writeFiles(t, map[string]string{
+		filepath.Join(xvm.GlobalGroupPath, "packs", "go", "pack", "config"):      "arch Target architecture\n",
+		filepath.Join(xvm.GlobalGroupPath, "packs", "go", "pack", "bin", "pull"): "#!/bin/sh\ntouch " + pulled + "\n",
+		filepath.Join(def, "bin", "pull"):                                        "#!/bin/sh\n",
+		filepath.Join(def, "available"):                                          "1.0\n",
+	})
+
+	xvm.DryRun = true
+	defer func() { xvm.DryRun = false }()
+
+	// The pull it would run is reported to the output of the pull.
+	out := new(bytes.Buffer)
+	if err := xvm.Pull("go", "1.9", out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "would run ") || !strings.Contains(out.String(), "  XVM_PULL_VERSION=1.9\n") {
+		t.Errorf("Expected the pull to be reported to its output, got %q", out)
+	}
+	if err := xvm.SetConfig("go", "1.9", "arch", "arm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xvm.AddPack("test", def, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		pulled,
+		xvm.PackPath("go", "installed"),
+		xvm.PackPath("go", "receipts"),
+		xvm.PackPath("go", "configs"),
+		xvm.PackPath("test"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected a dry run not to create %s", path)
+		}
+	}
+}
+
+func TestResolveBin(t *testing.T) {
+	dir := filepath.Join(root, "resolve-bin")
+	defer os.RemoveAll(dir)
+	benchGroup(t, dir, 2, 2, 1)
+
+	if err := xvm.MapGroups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an index, executables are resolved by loading every pack, but
+	// looking one up does not write the index.
+	path, err := xvm.ResolveBin("p0b0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "packs", "p0", "installed", "v0", "bin", "p0b0"); path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+	if _, err := os.Stat(xvm.IndexPath()); !os.IsNotExist(err) {
+		t.Errorf("Expected a lookup not to write the index, got %v", err)
+	}
+
+	// Build the index, as install and drop would.
+	if _, err := xvm.UpdateIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if temps, _ := filepath.Glob(xvm.IndexPath() + ".*"); len(temps) > 0 {
+		t.Errorf("Expected the index to be renamed into place, found %v", temps)
+	}
+	built, err := ioutil.ReadFile(xvm.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving an alias must invalidate the index, however soon it happens.
+	aliases := filepath.Join(dir, "packs", "p0", "aliases")
+	writeFiles(t, map[string]string{aliases: "stable v1\n"})
+	moved := time.Now().Add(time.Hour)
+	if err := os.Chtimes(aliases, moved, moved); err != nil {
+		t.Fatal(err)
+	}
+	if path, err = xvm.ResolveBin("p0b0"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "packs", "p0", "installed", "v1", "bin", "p0b0"); path != expected {
+		t.Errorf("Expected %s after moving stable, got %s", expected, path)
+	}
+	if index, _ := ioutil.ReadFile(xvm.IndexPath()); string(index) != string(built) {
+		t.Error("Expected a stale index to be left for commands to rewrite")
+	}
+
+	if _, err := xvm.ResolveBin("missing"); err == nil {
+		t.Error("Expected an error resolving a missing executable")
+	}
+}
+
+func TestFindExternal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("external commands need an extension")
+	}
+
+	dir := filepath.Join(root, "find-external")
+	defer os.RemoveAll(dir)
+	benchGroup(t, dir, 1, 1, 0)
+
+	path := filepath.Join(dir, "path")
+	expected := map[string]string{
+		"global":  filepath.Join(dir, "bin", "xvm-global"),
+		"version": filepath.Join(dir, "packs", "p0", "installed", "v0", "bin", "xvm-version"),
+		"def":     filepath.Join(dir, "packs", "q", "pack", "bin", "xvm-def"),
+		"path":    filepath.Join(path, "xvm-path"),
+	}
+	files := make(map[string]string)
+	for _, file := range expected {
+		files[file] = "#!/bin/sh\n"
+	}
+	writeFiles(t, files)
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", path+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if err := xvm.MapGroups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for command, file := range expected {
+		if actual, err := xvm.FindExternal(command); err != nil || actual != file {
+			t.Errorf("Expected %s to run %s, got %s: %v", command, file, actual, err)
+		}
+	}
+	for _, command := range []string{"missing", "../bin/xvm-global", ""} {
+		if _, err := xvm.FindExternal(command); err == nil {
+			t.Errorf("Expected not to find %q", command)
+		}
+	}
+
+	// With an index, commands it does not list are missing without loading
+	// every pack, and looking them up leaves the index as it is.
+	if _, err := xvm.UpdateIndex(); err != nil {
+		t.Fatal(err)
+	}
+	built, err := ioutil.ReadFile(xvm.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{filepath.Join(dir, "packs", "p0", "installed", "v0", "bin", "xvm-late"): "#!/bin/sh\n"})
+	if actual, err := xvm.FindExternal("late"); err == nil {
+		t.Errorf("Expected late to be missing from the index, got %s", actual)
+	}
+	if actual, err := xvm.FindExternal("version"); err != nil || actual != expected["version"] {
+		t.Errorf("Expected version to run %s, got %s: %v", expected["version"], actual, err)
+	}
+	if index, _ := ioutil.ReadFile(xvm.IndexPath()); string(index) != string(built) {
+		t.Error("Expected looking up commands to leave the index")
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	dir := filepath.Join(root, "match-version")
+	defer os.RemoveAll(dir)
+
+	xvm.GlobalGroupPath = dir
+	xvm.GroupPaths = []string{dir}
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "versions"):                                           "go 1.9\n",
+		filepath.Join(dir, "packs", "go", "installed", "1.9.2", "bin", "go"):     "",
+		filepath.Join(dir, "packs", "go", "installed", "1.9.4", "bin", "go"):     "",
+		filepath.Join(dir, "packs", "go", "installed", "1.9.10rc1", "bin", "go"): "",
+		filepath.Join(dir, "packs", "go", "installed", "1.90", "bin", "go"):      "",
+		filepath.Join(dir, "packs", "go", "installed", "1.8", "bin", "go"):       "",
+	})
+	if err := xvm.Load(context.Background(), xvm.NeedGroups|xvm.NeedAvailable|xvm.NeedInstalled|xvm.NeedAliases); err != nil {
+		t.Fatal(err)
+	}
+
+	for partial, expected := range map[string]string{"1.9": "1.9.4", "1.9.2": "1.9.2", "1.90": "1.90", "1": "1.90"} {
+		if actual, ok := xvm.ResolveInstalled("go", partial); !ok || actual != expected {
+			t.Errorf("Expected %s to match %s, got %s", partial, expected, actual)
+		}
+	}
+	if actual, ok := xvm.ResolveInstalled("go", "1.7"); ok {
+		t.Errorf("Expected 1.7 to match nothing, got %s", actual)
+	}
+
+	if _, err := xvm.ResolveDrop("go", "1.9"); err == nil {
+		t.Error("Expected dropping 1.9 to be ambiguous")
+	}
+	if actual, err := xvm.ResolveDrop("go", "1.8"); err != nil || actual != "1.8" {
+		t.Errorf("Expected to drop 1.8, got %s: %v", actual, err)
+	}
+
+	path, err := xvm.ResolveBin("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "packs", "go", "installed", "1.9.4", "bin", "go"); path != expected {
+		t.Errorf("Expected the go shim to run %s, got %s", expected, path)
+	}
+}
+
+func BenchmarkResolveBinIndex(b *testing.B) {
+	dir := filepath.Join(root, "bench-index")
+	defer os.RemoveAll(dir)
+	benchGroup(b, dir, 30, 10, 5)
+
+	// Build the index once, as install and drop would.
+	if _, err := xvm.UpdateIndex(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := xvm.MapGroups(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := xvm.ResolveBin("p0b0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkResolveBinLoad(b *testing.B) {
+	dir := filepath.Join(root, "bench-load")
+	defer os.RemoveAll(dir)
+	benchGroup(b, dir, 30, 10, 5)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := xvm.Load(context.Background(), xvm.NeedAll); err != nil {
+			b.Fatal(err)
+		}
+		if xvm.BinMap()["p0b0"] != "p0" {
+			b.Fatal("p0b0 was not loaded")
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	dir := filepath.Join(root, "require")
+	defer os.RemoveAll(dir)
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFiles(t, map[string]string{
+		filepath.Join(a, "packs", "a", "installed", "1.0", "bin", "a"): "",
+		filepath.Join(b, "packs", "b", "installed", "1.0", "bin", "b"): "",
+	})
+
+	xvm.GlobalGroupPath = a
+	xvm.GroupPaths = []string{a}
+	if err := xvm.Load(context.Background(), xvm.NeedInstalled); err != nil {
+		t.Fatal(err)
+	}
+
+	// Required maps which are already loaded are not loaded again.
+	xvm.GlobalGroupPath = b
+	xvm.GroupPaths = []string{b}
+	if err := xvm.Require(xvm.NeedInstalled); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := xvm.InstalledMap()["a"]; !ok {
+		t.Error("Expected Require to keep the loaded installations")
+	}
+
+	if err := xvm.Load(context.Background(), xvm.NeedInstalled); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := xvm.InstalledMap()["b"]; !ok {
+		t.Error("Expected Load to reload the installations")
+	}
+
+	// Functions which load maps on demand report the maps which failed.
+	badFile(t, filepath.Join(b, "packs", "b", "aliases"))
+	xvm.Unload()
+	if _, err := xvm.References(); err == nil {
+		t.Error("Expected References to report the unreadable aliases of b")
+	}
+}
+
+func TestRemote(t *testing.T) {
+	dir := filepath.Join(root, "remote")
+	defer os.RemoveAll(dir)
+	xvm.GlobalGroupPath = dir
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		io.WriteString(w, "1.0 2017-01-01\n2.0rc1 2017-06-01 prerelease\n")
+	}))
+	defer server.Close()
+
+	writeFiles(t, map[string]string{
+		xvm.PackPath("indexed", "pack", "info"): "index " + server.URL + "\n",
+	})
+	if runtime.GOOS != "windows" {
+		writeFiles(t, map[string]string{
+			xvm.PackPath("listed", "pack", "bin", "list"): "#!/bin/sh\necho 3.0 2017-09-01\n",
+		})
+		versions, err := xvm.Remote("listed")
+		if err != nil {
+			t.Error(err)
+		} else if versions["3.0"] != "2017-09-01" {
+			t.Errorf("Expected 3.0 to be listed, got %v", versions)
+		}
+	}
+
+	versions, err := xvm.FreshAvailable("indexed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions["2.0rc1"] != "2017-06-01 prerelease" {
+		t.Errorf("Expected 2.0rc1 to be a prerelease, got %v", versions)
+	}
+
+	// Versions fetched within the TTL are read from the cache.
+	if _, err := xvm.FreshAvailable("indexed"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch within the TTL, got %d", fetches)
+	}
+
+	os.Setenv("XVM_AVAILABLE_TTL", "0s")
+	defer os.Unsetenv("XVM_AVAILABLE_TTL")
+	if _, err := xvm.FreshAvailable("indexed"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches after the TTL, got %d", fetches)
+	}
+	if _, err := os.Stat(xvm.PackPath("indexed", "available")); !os.IsNotExist(err) {
+		t.Errorf("Expected fetched versions to be kept apart from the available file, got %v", err)
+	}
+
+	if xvm.HasRemote("static") {
+		t.Error("Expected a pack without list or index to have no remote")
+	}
+
+	for _, name := range []string{"..", "../x", ""} {
+		if _, err := xvm.UpdateAvailable(name); err == nil {
+			t.Errorf("Expected updating %q to be rejected", name)
+		}
+		if _, err := xvm.FreshAvailable(name); err == nil {
+			t.Errorf("Expected reading %q to be rejected", name)
+		}
+	}
 }
@@ -2,9 +2,18 @@
 
 package main
 
+import (
+	"os"
+)
+
 // Platform-specific filesystem defaults.
 const (
 	OSExt  = ""     // unix binaries need no extensions
 	OSDir  = ".xvm" // name of hidden directory for local groups
 	OSHome = "HOME" // path of default global group
 )
+
+// OSExecutable reports whether a file can be executed by its owner.
+func OSExecutable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
@@ -2,9 +2,24 @@
 
 package main
 
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 // Platform-specific filesystem defaults.
 const (
 	OSExt  = ".exe"        // windows binaries need an extension; go compiles to *.exe
 	OSDir  = "xvm"         // name of directory for local groups
 	OSHome = "USERPROFILE" // path of default global group
 )
+
+// OSExecutable reports whether a file has an extension windows can execute.
+func OSExecutable(info os.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(info.Name())) {
+	case ".exe", ".bat", ".cmd", ".com":
+		return info.Mode().IsRegular()
+	}
+	return false
+}